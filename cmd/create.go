@@ -29,15 +29,39 @@ import (
 
 // createCmd represents the create command
 var createCmd = &cobra.Command{
-	Use:   "create",
+	Use:   "create [SERVICE...]",
 	Short: "Create instances and volumes for services",
-	Long:  `Create instances and volumes for services.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		slog.Info("Create command", slog.String("app", app.Name))
-		slog.Warn("Not implemented")
+	Long: `Create instances and volumes for services.
+
+Creates the default network and the instances, volumes, bind mounts and
+secrets declared in the compose file, without starting the instances.
+Use 'start' or 'up' to start them. Given services are created together with
+the services they depend on, unless --no-deps is set.
+
+Existing instances are updated in place when their environment, labels,
+ports, devices, profiles or snapshot settings differ from the compose file.
+Changes that can't be applied in place, such as a different storage pool,
+recreate the instance. Use --force-recreate to always recreate instances and
+--no-recreate to never recreate them.`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Info("Creating", slog.String("app", app.Name))
+
+		services, err := selectServices(cmd, args, app.WithDependencies)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			return printPlan(app.PlanCreate(cmd.Context(), services, recreatePolicy(cmd)))
+		}
+		return app.Create(cmd.Context(), services, recreatePolicy(cmd))
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(createCmd)
+	createCmd.Flags().Bool("no-deps", false, "Don't create linked services")
+	addTimeoutFlag(createCmd)
+	addRecreateFlags(createCmd)
 }
@@ -0,0 +1,123 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/incus-compose/pkg/ui"
+	"github.com/bketelsen/toolbox/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff [SERVICE...]",
+	Short: "Show how instances differ from the compose file",
+	Long: `Show how instances differ from the compose file.
+
+Compares the instance of every service, its devices and custom volumes, and
+the default network of the stack with what the compose file and its x-incus
+extensions describe. Only configuration incus-compose manages is compared,
+changes made to other keys by hand or by the image are not reported.
+Changes marked with recreate can only be applied by recreating the instance.
+
+Exits with status 0 when nothing differs and 10 when something differs, so it
+can be run from cron or CI. Failures exit with the codes listed in the help of
+incus-compose.`,
+	Aliases: []string{"drift"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug("Diff", slog.String("app", app.Name))
+
+		services, err := selectServices(cmd, args, nil)
+		if err != nil {
+			return err
+		}
+
+		drifted, err := diff(cmd.Context(), services, cmd.Flag("format").Value.String())
+		if err != nil {
+			return err
+		}
+		if drifted {
+			return types.ErrDrift
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().String("format", "table", "Output format (table, json or yaml)")
+}
+
+// diff prints the differences of the services and reports whether there
+// were any
+func diff(ctx context.Context, services []string, format string) (bool, error) {
+	drifts, err := app.Diff(ctx, services)
+	if err != nil {
+		return false, err
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return len(drifts) > 0, enc.Encode(drifts)
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		defer enc.Close()
+		return len(drifts) > 0, enc.Encode(drifts)
+	case "table":
+		if len(drifts) == 0 {
+			fmt.Println("No differences")
+			return false, nil
+		}
+		rows := []ui.DiffRow{}
+		for _, d := range drifts {
+			rows = append(rows, diffRow(d))
+		}
+		ui.Diff(rows)
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: unsupported format %q", types.ErrUsage, format)
+	}
+}
+
+func diffRow(d application.Drift) ui.DiffRow {
+	change := d.Change
+	if d.Recreate {
+		change += " (recreate)"
+	}
+	return ui.DiffRow{
+		Service: d.Service,
+		Object:  d.Object,
+		Kind:    d.Kind,
+		Name:    d.Name,
+		Change:  change,
+	}
+}
@@ -22,7 +22,6 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
 	"log/slog"
 
 	"github.com/bketelsen/toolbox/cobra"
@@ -35,14 +34,25 @@ var downCmd = &cobra.Command{
 	Long: `Stop and remove instances
 
 Optionally remove custom storage volumes declared in the compose file, with the --volumes flag.
+
+With --remove-orphans, instances and networks labeled with the stack whose
+service is no longer in the compose file are removed too, and their volumes
+when --volumes is set.
+
+Use --resume to continue a run that was interrupted or failed, with the
+options it was started with.
 `,
-	Run: func(cmd *cobra.Command, args []string) {
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
 		cmd.Logger.Info("Down", slog.String("app", app.Name))
 
-		err := app.Down(cmd.Flag("force").Changed, cmd.Flag("volumes").Changed, timeout)
-		if err != nil {
-			fmt.Println(err)
+		if boolFlag(cmd, "resume") {
+			return resume(cmd, args, "down")
+		}
+		if dryRun {
+			return printPlan(app.PlanDown(cmd.Context(), boolFlag(cmd, "force"), boolFlag(cmd, "volumes"), boolFlag(cmd, "remove-orphans"), timeout))
 		}
+		return app.Down(cmd.Context(), boolFlag(cmd, "force"), boolFlag(cmd, "volumes"), boolFlag(cmd, "remove-orphans"), timeout)
 	},
 }
 
@@ -51,5 +61,7 @@ func init() {
 	downCmd.Flags().BoolP("force", "f", false, "Don't ask for confirmation before removing instances")
 	downCmd.Flags().BoolP("volumes", "v", false, "Remove named volumes declared in the 'volumes' section of the compose file")
 	downCmd.Flags().IntVarP(&timeout, "timeout", "t", -1, "Specify a shutdown timeout in seconds")
+	downCmd.Flags().Bool("resume", false, "Continue the last run that didn't complete")
+	downCmd.Flags().Bool("remove-orphans", false, "Remove instances, networks and (with --volumes) volumes of services that are no longer in the compose file")
 
 }
@@ -0,0 +1,124 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/toolbox/cobra"
+)
+
+// logFormat is the format of the log lines written to stderr, text or json
+var logFormat string
+
+// events writes the actions applied to the stack as JSON events, to stderr
+// with the logs unless eventsFile is set
+var events bool
+
+// eventsFile is the file events are appended to, - for stdout. Setting it
+// turns events on.
+var eventsFile string
+
+var eventWriter *application.EventWriter
+var closeEvents = func() error { return nil }
+var commandStart = time.Now()
+
+// setupLogging replaces the text logger with a JSON logger when
+// --log-format json is set
+func setupLogging(cmd *cobra.Command) error {
+	switch logFormat {
+	case "text":
+		return nil
+	case "json":
+		level := slog.LevelInfo
+		if cmd.GlobalConfig().GetBool("verbose") {
+			level = slog.LevelDebug
+		}
+		logger := slog.New(slog.NewJSONHandler(cmd.ErrOrStderr(), &slog.HandlerOptions{Level: level}))
+		cmd.SetLogger(logger)
+		slog.SetDefault(logger)
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported log format %q", types.ErrUsage, logFormat)
+	}
+}
+
+// startEvents tags the JSON logs with the stack and registers the event
+// writer when --events or --events-file is set
+func startEvents(cmd *cobra.Command) error {
+	if logFormat == "json" {
+		logger := cmd.Logger.With(slog.String("stack", app.Name))
+		cmd.SetLogger(logger)
+		slog.SetDefault(logger)
+	}
+	if !events && eventsFile == "" {
+		return nil
+	}
+
+	var w io.Writer
+	switch eventsFile {
+	case "":
+		w = cmd.ErrOrStderr()
+	case "-":
+		w = cmd.OutOrStdout()
+	default:
+		f, err := os.OpenFile(eventsFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed opening events file: %w", err)
+		}
+		w = f
+		closeEvents = f.Close
+	}
+	eventWriter = application.NewEventWriter(w, app.Name)
+	app.AddHook(eventWriter)
+	return nil
+}
+
+// finishEvents writes the event of the command that ran
+func finishEvents(cmd *cobra.Command, err error) {
+	if eventWriter == nil {
+		return
+	}
+	event := application.Event{
+		Action:     cmd.Name(),
+		Kind:       "command",
+		Resource:   app.Name,
+		Details:    map[string]string{"exit": strconv.Itoa(types.ExitCode(err))},
+		DurationMS: time.Since(commandStart).Milliseconds(),
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	eventWriter.Write(event)
+
+	cerr := closeEvents()
+	if cerr != nil {
+		slog.Warn("Events file not closed", slog.String("error", cerr.Error()))
+	}
+}
@@ -22,32 +22,212 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
 	"github.com/bketelsen/toolbox/cobra"
+	"github.com/gorilla/websocket"
+	"github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/termios"
 )
 
 // execCmd represents the exec command
 var execCmd = &cobra.Command{
-	Use:   "exec",
+	Use:   "exec [flags] SERVICE -- COMMAND [ARGS...]",
+	Args:  usageArgs(cobra.MinimumNArgs(2)),
 	Short: "Execute a command in a running instance",
-	Long:  `Execute a command in a running instance`,
-	Run: func(cmd *cobra.Command, args []string) {
-		slog.Info("Exec command", slog.String("app", app.Name))
-		slog.Warn("Not implemented")
+	Long: `Execute a command in a running instance
+
+The command is run in the instance of the given service. A pseudo-terminal is
+allocated when both stdin and stdout are terminals, unless -T is passed.
+The exit code of the command is used as the exit code of incus-compose.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug("Exec command", slog.String("app", app.Name), slog.String("service", args[0]))
+
+		if dryRun {
+			return fmt.Errorf("%w: --dry-run is not supported", types.ErrUsage)
+		}
+
+		command := args[1:]
+		if command[0] == "--" {
+			command = command[1:]
+		}
+		if len(command) == 0 {
+			return fmt.Errorf("%w: no command given", types.ErrUsage)
+		}
+
+		code, err := execService(cmd, args[0], command)
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return &types.ExitError{Code: code}
+		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(execCmd)
 
-	// Here you will define your flags and configuration settings.
+	// everything after the service name belongs to the command
+	execCmd.Flags().SetInterspersed(false)
+	addExecFlags(execCmd)
+}
+
+func execService(cmd *cobra.Command, service string, command []string) (int, error) {
+	opts, restore, err := execOptions(cmd)
+	if err != nil {
+		return -1, err
+	}
+	defer restore()
+
+	// signals are forwarded to the command, so waiting for it isn't cancelled
+	ctx := context.WithoutCancel(cmd.Context())
+	return app.ExecContainerForService(ctx, service, command, opts)
+}
+
+// execOptions builds the exec options from the exec flags shared by exec and
+// run, and puts the terminal in raw mode for interactive commands. The
+// returned function restores the terminal.
+func execOptions(cmd *cobra.Command) (application.ExecOptions, func(), error) {
+	noTTY, _ := cmd.Flags().GetBool("no-tty")
+	user, _ := cmd.Flags().GetString("user")
+	workdir, _ := cmd.Flags().GetString("workdir")
+	envs, _ := cmd.Flags().GetStringArray("env")
+
+	restore := func() {}
+	opts := application.ExecOptions{
+		Environment: map[string]string{},
+		Cwd:         workdir,
+		Stdin:       os.Stdin,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+	}
+
+	var err error
+	opts.User, opts.Group, err = parseUser(user)
+	if err != nil {
+		return opts, restore, err
+	}
+
+	for _, env := range envs {
+		pieces := strings.SplitN(env, "=", 2)
+		value := ""
+		if len(pieces) > 1 {
+			value = pieces[1]
+		}
+		opts.Environment[pieces[0]] = value
+	}
+
+	stdinFd := int(os.Stdin.Fd())
+	stdoutFd := int(os.Stdout.Fd())
+	opts.Interactive = !noTTY && termios.IsTerminal(stdinFd) && termios.IsTerminal(stdoutFd)
+
+	if opts.Interactive {
+		if term, ok := os.LookupEnv("TERM"); ok {
+			opts.Environment["TERM"] = term
+		}
+
+		opts.Width, opts.Height, err = termios.GetSize(stdoutFd)
+		if err != nil {
+			return opts, restore, err
+		}
+
+		oldState, err := termios.MakeRaw(stdinFd)
+		if err != nil {
+			return opts, restore, err
+		}
+		restore = func() { _ = termios.Restore(stdinFd, oldState) }
+	}
+
+	opts.Control = func(control *websocket.Conn, done <-chan struct{}) {
+		execControlHandler(control, done, opts.Interactive, stdoutFd)
+	}
+
+	return opts, restore, nil
+}
+
+// addExecFlags adds the flags that control how a command is executed
+func addExecFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("no-tty", "T", false, "Disable pseudo-terminal allocation")
+	cmd.Flags().StringP("user", "u", "", "Run the command as this user id, in the form uid[:gid]")
+	cmd.Flags().StringP("workdir", "w", "", "Working directory for the command")
+	cmd.Flags().StringArrayP("env", "e", nil, "Set environment variables (e.g. FOO=bar)")
+}
+
+// execControlHandler forwards window size changes and signals to the command
+// running in the instance, until done is closed.
+func execControlHandler(control *websocket.Conn, done <-chan struct{}, interactive bool, stdoutFd int) {
+	ch := make(chan os.Signal, 10)
+	signal.Notify(ch, syscall.SIGWINCH, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT)
+	defer signal.Stop(ch)
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	defer func() { _ = control.WriteMessage(websocket.CloseMessage, closeMsg) }()
+
+	for {
+		var sig os.Signal
+		select {
+		case <-done:
+			// the command has finished, signals are handled by incus-compose again
+			return
+		case sig = <-ch:
+		}
+
+		msg := api.InstanceExecControl{}
+		if sig == syscall.SIGWINCH {
+			if !interactive {
+				continue
+			}
+			width, height, err := termios.GetSize(stdoutFd)
+			if err != nil {
+				return
+			}
+			msg.Command = "window-resize"
+			msg.Args = map[string]string{
+				"width":  strconv.Itoa(width),
+				"height": strconv.Itoa(height),
+			}
+		} else {
+			msg.Command = "signal"
+			msg.Signal = int(sig.(syscall.Signal))
+		}
+
+		err := control.WriteJSON(msg)
+		if err != nil {
+			slog.Debug("Exec control", slog.String("error", err.Error()))
+			return
+		}
+	}
+}
+
+// parseUser parses a uid[:gid] user specification.
+func parseUser(user string) (uint32, uint32, error) {
+	if user == "" {
+		return 0, 0, nil
+	}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// execCmd.PersistentFlags().String("foo", "", "A help for foo")
+	uidStr, gidStr, _ := strings.Cut(user, ":")
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: --user must be a numeric uid[:gid]", types.ErrUsage)
+	}
+	if gidStr == "" {
+		return uint32(uid), 0, nil
+	}
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// execCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%w: --user must be a numeric uid[:gid]", types.ErrUsage)
+	}
+	return uint32(uid), uint32(gid), nil
 }
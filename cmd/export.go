@@ -22,7 +22,6 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
 	"log/slog"
 
 	"github.com/bketelsen/toolbox/cobra"
@@ -30,16 +29,17 @@ import (
 
 // exportCmd represents the export command
 var exportCmd = &cobra.Command{
-	Use:   "export",
-	Short: "Export backup of instances and volumes",
-	Long:  `Export backup of instances and volumes`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:         "export",
+	Short:       "Export backup of instances and volumes",
+	Long:        `Export backup of instances and volumes`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
 		slog.Info("Exporting", slog.String("app", app.Name))
 
-		err := app.Export(cmd.Flag("volumes").Changed, cmd.Flag("only-volumes").Changed)
-		if err != nil {
-			fmt.Println(err)
+		if dryRun {
+			return printPlan(app.PlanExport(cmd.Context(), boolFlag(cmd, "volumes"), boolFlag(cmd, "only-volumes")))
 		}
+		return app.Export(cmd.Context(), boolFlag(cmd, "volumes"), boolFlag(cmd, "only-volumes"))
 	},
 }
 
@@ -47,5 +47,6 @@ func init() {
 	rootCmd.AddCommand(exportCmd)
 	exportCmd.Flags().BoolP("volumes", "v", false, "Export volumes, including bind mounts (probably not be what you want)")
 	exportCmd.Flags().BoolP("only-volumes", "o", false, "Only export custom volumes")
+	addTimeoutFlag(exportCmd)
 
 }
@@ -23,7 +23,6 @@ package cmd
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
@@ -44,7 +43,7 @@ and usage of using your command. For example:
 Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		bp := cmd.Config().GetString("basepath")
 		cmd.Logger.Info("Base path for documentation", "basepath", bp)
 		linkHandler := func(name string) string {
@@ -60,12 +59,9 @@ to quickly create a Cobra application.`,
 		}
 		err := os.MkdirAll("./content/docs/cli/", 0755)
 		if err != nil {
-			log.Fatal(err)
-		}
-		err = doc.GenMarkdownTreeCustom(rootCmd, "./content/docs/cli/", filePrepender, linkHandler)
-		if err != nil {
-			log.Fatal(err)
+			return err
 		}
+		return doc.GenMarkdownTreeCustom(rootCmd, "./content/docs/cli/", filePrepender, linkHandler)
 	},
 }
 
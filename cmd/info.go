@@ -29,20 +29,20 @@ import (
 
 // infoCmd represents the info command
 var infoCmd = &cobra.Command{
-	Use:  "info",
-	Args: cobra.MaximumNArgs(1),
+	Use: "info [SERVICE...]",
 
 	Short: "Display information about instances",
 	Long:  `Display information about instances`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		slog.Info("Info", slog.String("app", app.Name))
 
-		err := app.Info()
+		services, err := selectServices(cmd, args, nil)
 		if err != nil {
-			slog.Error("Info", slog.String("error", err.Error()))
+			return err
 		}
 
+		return app.Info(cmd.Context(), services)
 	},
 }
 
@@ -0,0 +1,85 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bketelsen/toolbox/cobra"
+)
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Show who holds the stack lock",
+	Long: `Show who holds the stack lock
+
+Commands that change the stack (up, create, down, rm, start, stop, restart,
+update, snapshot and export) hold an advisory lock on it while they run, so
+two runs can't work on the same stack at once. The lock is a key on the
+Incus project of the stack recording the user, host, PID, command and start
+time of the run holding it.
+
+A run that finds the stack locked fails, unless --wait is set to wait for
+the lock. Use 'lock break' to remove the lock of a run that was killed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		holder, err := app.LockHolder()
+		if err != nil {
+			return err
+		}
+		if holder == nil {
+			fmt.Printf("Stack %s is not locked\n", app.Name)
+			return nil
+		}
+		fmt.Printf("Stack %s is locked by %s\n", app.Name, holder)
+		return nil
+	},
+}
+
+// lockBreakCmd represents the lock break command
+var lockBreakCmd = &cobra.Command{
+	Use:   "break",
+	Short: "Remove the stack lock",
+	Long: `Remove the stack lock
+
+Removes the lock of the stack whoever holds it. Only use it when the run
+holding the lock was killed or crashed, breaking the lock of a run that is
+still going lets another run work on the stack at the same time.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		holder, err := app.BreakLock()
+		if err != nil {
+			return err
+		}
+		if holder == nil {
+			fmt.Printf("Stack %s is not locked\n", app.Name)
+			return nil
+		}
+		slog.Warn("Lock broken", slog.String("stack", app.Name), slog.String("holder", holder.String()))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	lockCmd.AddCommand(lockBreakCmd)
+}
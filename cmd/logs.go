@@ -0,0 +1,79 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/toolbox/cobra"
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs [flags] [SERVICE...]",
+	Short: "View output from instances",
+	Long: `View output from instances
+
+Shows the logs of the given services, or of all services. The console log is
+used for instances created from OCI images, the journal for system images.
+Lines are prefixed with the name of their service.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug("Logs", slog.String("app", app.Name))
+
+		tail, err := parseTail(cmd.Flag("tail").Value.String())
+		if err != nil {
+			return err
+		}
+
+		opts := application.LogOptions{
+			Follow:     boolFlag(cmd, "follow"),
+			Tail:       tail,
+			Timestamps: boolFlag(cmd, "timestamps"),
+		}
+
+		return app.Logs(cmd.Context(), args, opts, os.Stdout)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().BoolP("follow", "f", false, "Follow log output")
+	logsCmd.Flags().StringP("tail", "n", "all", "Number of lines to show from the end of the logs")
+	logsCmd.Flags().BoolP("timestamps", "t", false, "Show timestamps")
+}
+
+// parseTail parses the --tail flag, "all" shows every line
+func parseTail(tail string) (int, error) {
+	if tail == "all" {
+		return -1, nil
+	}
+	n, err := strconv.Atoi(tail)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w: invalid --tail value %q", types.ErrUsage, tail)
+	}
+	return n, nil
+}
@@ -0,0 +1,96 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/incus-compose/pkg/ui"
+	"github.com/bketelsen/toolbox/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// lsCmd represents the ls command
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List stacks",
+	Long: `List stacks
+
+Lists the incus-compose stacks found on a remote with their working
+directory, how many of their services are running and the projects they are
+deployed to. Stacks are discovered from the keys incus-compose sets on the
+instances it creates, so no compose file is needed.
+
+Only the current project is searched, use --all-projects to search every
+project and --remote to list the stacks of another remote.`,
+	Annotations: map[string]string{skipProjectAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug("Ls")
+
+		return ls(cmd.Context(), cmd.Flag("remote").Value.String(), boolFlag(cmd, "all-projects"), cmd.Flag("format").Value.String())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lsCmd)
+	lsCmd.Flags().Bool("all-projects", false, "List stacks in all projects")
+	lsCmd.Flags().String("remote", "", "Remote to list stacks from (default is the default remote)")
+	lsCmd.Flags().String("format", "table", "Output format (table, json or yaml)")
+}
+
+func ls(ctx context.Context, remote string, allProjects bool, format string) error {
+	stacks, err := application.ListStacks(ctx, conf, remote, allProjects)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stacks)
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		defer enc.Close()
+		return enc.Encode(stacks)
+	case "table":
+		rows := []ui.StackRow{}
+		for _, s := range stacks {
+			rows = append(rows, ui.StackRow{
+				Name:      s.Name,
+				Directory: s.Directory,
+				Services:  fmt.Sprintf("%d/%d running", s.Running, s.Services),
+				Projects:  s.Projects,
+			})
+		}
+		ui.Stacks(rows)
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported format %q", types.ErrUsage, format)
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/incus-compose/pkg/ui"
+)
+
+var planFormat string
+
+// checkPlanFormat checks the format selected with --plan-format
+func checkPlanFormat() error {
+	if planFormat != "text" && planFormat != "json" {
+		return fmt.Errorf("%w: unsupported plan format %q", types.ErrUsage, planFormat)
+	}
+	return nil
+}
+
+// printPlan prints the plan computed for --dry-run in the format selected
+// with --plan-format, or passes on the error of computing it
+func printPlan(plan *application.Plan, err error) error {
+	if err != nil {
+		return err
+	}
+
+	// the format was checked before the plan was computed
+	switch planFormat {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(plan)
+	case "text":
+		if len(plan.Actions) == 0 {
+			fmt.Println("No changes")
+			return nil
+		}
+		rows := []ui.PlanRow{}
+		for _, a := range plan.Actions {
+			details := []string{}
+			for _, k := range slices.Sorted(maps.Keys(a.Details)) {
+				details = append(details, k+"="+a.Details[k])
+			}
+			rows = append(rows, ui.PlanRow{
+				Action:  a.Verb,
+				Kind:    a.Kind,
+				Service: a.Service,
+				Name:    a.Name,
+				Details: details,
+			})
+		}
+		ui.Plan(rows)
+	}
+	return nil
+}
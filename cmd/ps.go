@@ -0,0 +1,106 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/incus-compose/pkg/ui"
+	"github.com/bketelsen/toolbox/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// psCmd represents the ps command
+var psCmd = &cobra.Command{
+	Use:   "ps",
+	Short: "List instances",
+	Long: `List instances
+
+Lists the instance of every service with its status, image, published ports,
+addresses and uptime. Use --format json or --format yaml for output that can
+be consumed by scripts, and --filter to only show some of the services, e.g.
+--filter status=running.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug("Ps", slog.String("app", app.Name))
+
+		return ps(cmd.Context(), cmd.Flag("format").Value.String(), psFilters)
+	},
+}
+
+var psFilters []string
+
+func init() {
+	rootCmd.AddCommand(psCmd)
+	psCmd.Flags().String("format", "table", "Output format (table, json or yaml)")
+	psCmd.Flags().StringArrayVar(&psFilters, "filter", nil, "Filter output based on conditions provided (status=VALUE or service=VALUE)")
+}
+
+func ps(ctx context.Context, format string, filters []string) error {
+	// bad flags are reported before asking Incus
+	if format != "table" && format != "json" && format != "yaml" {
+		return fmt.Errorf("%w: unsupported format %q", types.ErrUsage, format)
+	}
+	parsed, err := application.ParseStatusFilters(filters)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := app.Ps(ctx)
+	if err != nil {
+		return err
+	}
+	statuses = application.FilterStatuses(statuses, parsed)
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		defer enc.Close()
+		return enc.Encode(statuses)
+	case "table":
+		rows := []ui.PsRow{}
+		for _, s := range statuses {
+			rows = append(rows, ui.PsRow{
+				Service:   s.Service,
+				Instance:  s.Instance,
+				Status:    s.Status,
+				Image:     s.Image,
+				Ports:     s.Ports,
+				Addresses: s.Addresses,
+				Uptime:    s.Uptime,
+			})
+		}
+		ui.Ps(rows)
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported format %q", types.ErrUsage, format)
+	}
+}
@@ -29,24 +29,33 @@ import (
 
 // restartCmd represents the restart command
 var restartCmd = &cobra.Command{
-	Use:  "restart",
-	Args: cobra.MaximumNArgs(1),
+	Use: "restart [SERVICE...]",
 
 	Short: "Restart instances",
-	Long:  `Restart instances.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Restart instances.
+
+Given services are restarted together with the services they depend on,
+unless --no-deps is set.`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		slog.Info("Restarting", slog.String("app", app.Name))
 
-		//	incus.Execute(context.Background(), []string{"ls"})
-		err := app.Restart()
+		services, err := selectServices(cmd, args, app.WithDependencies)
 		if err != nil {
-			slog.Error("Restart", slog.String("error", err.Error()))
+			return err
+		}
+
+		if dryRun {
+			return printPlan(app.PlanRestart(cmd.Context(), services))
 		}
+		return app.Restart(cmd.Context(), services)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(restartCmd)
+	restartCmd.Flags().Bool("no-deps", false, "Don't restart linked services")
+	addTimeoutFlag(restartCmd)
 
 }
@@ -29,23 +29,32 @@ import (
 
 // rmCmd represents the rm command
 var rmCmd = &cobra.Command{
-	Use:  "rm",
-	Args: cobra.MaximumNArgs(1),
+	Use: "rm [SERVICE...]",
 
 	Short: "Remove stopped instances",
 	Long: `Remove stopped instances
 	
 Remove stopped instances declared in the compose file. By default, volumes
 declared in the compose file are not removed. You can override this with the
---volumes flag.`,
-	Run: func(cmd *cobra.Command, args []string) {
+--volumes flag.
+
+Given services are removed together with the services that depend on them,
+unless --no-deps is set. The default network is only removed together with
+the last service.`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		slog.Info("Removing", slog.String("app", app.Name))
 
-		err := app.Remove(timeout, cmd.Flag("force").Changed, cmd.Flag("stop").Changed, cmd.Flag("volumes").Changed)
+		services, err := selectServices(cmd, args, app.WithDependents)
 		if err != nil {
-			slog.Error("Remove", slog.String("error", err.Error()))
+			return err
+		}
+
+		if dryRun {
+			return printPlan(app.PlanRemove(cmd.Context(), services, timeout, boolFlag(cmd, "force"), boolFlag(cmd, "stop"), boolFlag(cmd, "volumes")))
 		}
+		return app.Remove(cmd.Context(), services, timeout, boolFlag(cmd, "force"), boolFlag(cmd, "stop"), boolFlag(cmd, "volumes"))
 	},
 }
 
@@ -55,5 +64,6 @@ func init() {
 	rmCmd.Flags().BoolP("force", "f", false, "Don't ask for confirmation before removing instances")
 	rmCmd.Flags().BoolP("stop", "s", false, "Stop the instances, if required, before removing")
 	rmCmd.Flags().BoolP("volumes", "v", false, "Remove named volumes declared in the compose file")
-	rmCmd.Flags().IntP("timeout", "t", 0, "Specify a shutdown timeout in seconds")
+	rmCmd.Flags().Bool("no-deps", false, "Don't remove services that depend on the given services")
+	rmCmd.Flags().IntVarP(&timeout, "timeout", "t", -1, "Specify a shutdown timeout in seconds")
 }
@@ -23,15 +23,21 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
 	"os/user"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/bketelsen/incus-compose/pkg/application"
 	"github.com/bketelsen/incus-compose/pkg/compose"
+	"github.com/bketelsen/incus-compose/pkg/types"
 	"github.com/spf13/viper"
 
 	dockercompose "github.com/compose-spec/compose-go/v2/types"
@@ -50,12 +56,30 @@ var forceLocal bool
 
 // var app application.Compose
 var timeout int
+var opTimeout int
+var globalTimeout time.Duration
+var cancelGlobalTimeout context.CancelFunc = func() {}
 var dryRun bool
+var parallel int
+var retryAttempts int
+var retryBackoff time.Duration
 var cwd string
 var project *dockercompose.Project
 var app *application.Compose
 
 var appname = "incus-compose"
+
+// skipProjectAnnotation marks commands that run without loading the compose
+// file. Only the Incus configuration is loaded for them.
+const skipProjectAnnotation = "incus-compose/skip-project"
+
+// lockAnnotation marks commands that change the stack and hold its lock
+// while they run
+const lockAnnotation = "incus-compose/lock"
+
+var waitLock bool
+var releaseLock = func() {}
+
 var (
 	version   = ""
 	commit    = ""
@@ -89,12 +113,28 @@ var rootCmd = &cobra.Command{
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) (err error) {
 		// set the slog default logger to the cobra logger
 		slog.SetDefault(cmd.Logger)
+		err = setupLogging(cmd)
+		if err != nil {
+			return err
+		}
+		// report a bad --plan-format before the plan is computed
+		if dryRun {
+			err = checkPlanFormat()
+			if err != nil {
+				return err
+			}
+		}
 		// set log level based on the --verbose flag
 		if cmd.GlobalConfig().GetBool("verbose") {
 			debug = true
 			cmd.SetLogLevel(slog.LevelDebug)
 			cmd.Logger.Debug("Debug logging enabled")
 		}
+		if globalTimeout > 0 {
+			ctx, cancel := context.WithTimeout(cmd.Context(), globalTimeout)
+			cancelGlobalTimeout = cancel
+			cmd.SetContext(ctx)
+		}
 		// skip all the rest for documentation generation and shell completion
 		if cmd.Name() == "gendocs" || cmd.Name() == "completion" || (cmd.Parent() != nil && cmd.Parent().Name() == "completion") {
 			return nil
@@ -164,8 +204,13 @@ var rootCmd = &cobra.Command{
 
 		conf.ProjectOverride = os.Getenv("INCUS_PROJECT")
 
+		// commands that work across stacks don't need a compose file
+		if cmd.Annotations[skipProjectAnnotation] == "true" {
+			return nil
+		}
+
 		loader := configureLoader(cmd)
-		project, err = loader.LoadProject(context.Background())
+		project, err = loader.LoadProject(cmd.Context())
 		if err != nil {
 			return err
 		}
@@ -173,6 +218,10 @@ var rootCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
+		app.Parallel = parallel
+		app.OperationTimeout = time.Duration(opTimeout) * time.Second
+		app.Retry.Attempts = retryAttempts
+		app.Retry.Backoff = retryBackoff
 		g := graph.New(graph.StringHash, graph.Directed(), graph.Acyclic())
 		for name := range app.Services {
 			_ = g.AddVertex(name)
@@ -183,11 +232,48 @@ var rootCmd = &cobra.Command{
 			}
 		}
 		app.Dag = g
+		err = startEvents(cmd)
+		if err != nil {
+			return err
+		}
+
+		if cmd.Annotations[lockAnnotation] == "true" && !dryRun {
+			unlock, err := app.Lock(cmd.Context(), cmd.Name(), waitLock)
+			if err != nil {
+				return err
+			}
+			releaseLock = func() {
+				err := unlock()
+				if err != nil {
+					slog.Error("Releasing lock", slog.String("error", err.Error()))
+				}
+			}
+		}
 		return nil
 	},
 	Version: bversion.String(),
 	Short:   "Define and run multi-instance applications with Incus",
-	Long:    `Define and run multi-instance applications with Incus`,
+	Long: `Define and run multi-instance applications with Incus
+
+Exit codes:
+  0    success
+  1    failure not covered below
+  2    invalid flags or arguments
+  3    no compose file found, or the compose file is invalid
+  4    sanity check failed, the compose file refers to projects, profiles,
+       storage pools or networks the Incus server doesn't have
+  5    service, instance, volume, network or run not found
+  6    conflict with the current state, like a running instance or a stack
+       locked by another run
+  7    timed out
+  8    error returned by Incus or the connection to it
+  10   diff found instances that differ from the compose file
+  11   status found that the last run didn't complete
+  130  interrupted
+
+exec and run exit with the exit code of the command they ran.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
@@ -195,18 +281,89 @@ var rootCmd = &cobra.Command{
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// Interrupting incus-compose cancels the running Incus operations.
 func Execute() {
-	err := rootCmd.Execute()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cmd, err := rootCmd.ExecuteContextC(ctx)
+	releaseLock()
+	cancelGlobalTimeout()
+	finishEvents(cmd, err)
 	if err != nil {
-		os.Exit(1)
+		code := types.ExitCode(err)
+		// the command run by exec or run already reported its failure
+		var exitErr *types.ExitError
+		if !errors.As(err, &exitErr) {
+			slog.Error("Failed", slog.String("command", cmd.CommandPath()), slog.String("error", err.Error()), slog.Int("exit", code))
+		}
+		if code == types.ExitUsage {
+			fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", cmd.CommandPath())
+		}
+		stop()
+		os.Exit(code)
 	}
 }
 
 func init() {
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return fmt.Errorf("%w: %w", types.ErrUsage, err)
+	})
 
 	rootCmd.PersistentFlags().StringVar(&cwd, "cwd", "", "change working directory")
-	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print commands that would be executed without running them")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "print the changes that would be made without making them")
+	rootCmd.PersistentFlags().StringVar(&planFormat, "plan-format", "text", "format of the --dry-run plan (text or json)")
 	rootCmd.PersistentFlags().BoolVarP(&debug, "verbose", "d", false, "verbose logging")
+	rootCmd.PersistentFlags().IntVar(&parallel, "parallel", 0, "maximum number of services to operate on concurrently (0 for no limit)")
+	rootCmd.PersistentFlags().IntVar(&retryAttempts, "retry-attempts", application.DefaultRetry.Attempts, "how many times an Incus call failing on a transient error is made (1 for no retries)")
+	rootCmd.PersistentFlags().DurationVar(&retryBackoff, "retry-backoff", application.DefaultRetry.Backoff, "delay before retrying a failed Incus call, doubled with every retry")
+	rootCmd.PersistentFlags().DurationVar(&globalTimeout, "global-timeout", 0, "maximum duration of the whole command, e.g. 10m (0 for no limit)")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "format of the log lines written to stderr (text or json)")
+	rootCmd.PersistentFlags().BoolVar(&events, "events", false, "write every action applied to the stack as a JSON event on stderr")
+	rootCmd.PersistentFlags().StringVar(&eventsFile, "events-file", "", "append the events to this file instead of stderr, - for stdout (implies --events)")
+	rootCmd.PersistentFlags().BoolVar(&waitLock, "wait", false, "wait for another run holding the stack lock to finish instead of failing")
+}
+
+// addTimeoutFlag adds the per-operation timeout flag to commands that create
+// or change instances and volumes.
+func addTimeoutFlag(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&opTimeout, "timeout", "t", 0, "Specify a timeout in seconds for each Incus operation (0 for no timeout)")
+}
+
+// boolFlag returns the value of a boolean flag, false when the command
+// doesn't have it. --flag=false turns the flag off.
+func boolFlag(cmd *cobra.Command, name string) bool {
+	value, err := cmd.Flags().GetBool(name)
+	return err == nil && value
+}
+
+// selectServices checks the service names given as arguments and expands
+// them, unless --no-deps is set. No arguments select all services.
+func selectServices(cmd *cobra.Command, args []string, expand func([]string) ([]string, error)) ([]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	noDeps := expand == nil || boolFlag(cmd, "no-deps")
+	if noDeps {
+		for _, service := range args {
+			if _, ok := app.Services[service]; !ok {
+				return nil, &types.NotFoundError{Kind: "service", Name: service}
+			}
+		}
+		return args, nil
+	}
+	return expand(args)
+}
+
+// usageArgs marks the errors of an argument validator as usage errors
+func usageArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		err := validate(cmd, args)
+		if err != nil {
+			return fmt.Errorf("%w: %w", types.ErrUsage, err)
+		}
+		return nil
+	}
 }
 
 func configureLoader(cmd *cobra.Command) compose.Loader {
@@ -22,23 +22,64 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"fmt"
 	"log/slog"
 
+	"github.com/bketelsen/incus-compose/pkg/types"
 	"github.com/bketelsen/toolbox/cobra"
 )
 
 // runCmd represents the run command
 var runCmd = &cobra.Command{
-	Use:   "run",
+	Use:   "run [flags] SERVICE [COMMAND] [ARGS...]",
+	Args:  usageArgs(cobra.MinimumNArgs(1)),
 	Short: "Run a one-off command on a service",
-	Long:  `Run a one-off command on a service.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		slog.Info("Run command", slog.String("app", app.Name))
-		slog.Warn("Not implemented")
+	Long: `Run a one-off command on a service.
 
+A new instance is created from the service definition, with the same profiles,
+environment, volumes, bind mounts and secrets, but without published ports.
+The command runs to completion with its output streamed to the terminal.
+Without a command, the service's command from the compose file is used.
+
+With --rm the instance is ephemeral and is deleted when the command finishes,
+otherwise it is left stopped. The exit code of the command is used as the exit
+code of incus-compose.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Info("Run command", slog.String("app", app.Name), slog.String("service", args[0]))
+
+		if dryRun {
+			return fmt.Errorf("%w: --dry-run is not supported", types.ErrUsage)
+		}
+
+		command := args[1:]
+		if len(command) > 0 && command[0] == "--" {
+			command = command[1:]
+		}
+
+		opts, restore, err := execOptions(cmd)
+		if err != nil {
+			return err
+		}
+
+		remove, _ := cmd.Flags().GetBool("rm")
+		code, err := app.RunContainerForService(cmd.Context(), args[0], command, remove, opts)
+		restore()
+		if err != nil {
+			return err
+		}
+		if code != 0 {
+			return &types.ExitError{Code: code}
+		}
+		return nil
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(runCmd)
+
+	// everything after the service name belongs to the command
+	runCmd.Flags().SetInterspersed(false)
+	runCmd.Flags().Bool("rm", false, "Remove the instance after the command finishes")
+	addExecFlags(runCmd)
+	addTimeoutFlag(runCmd)
 }
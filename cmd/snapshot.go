@@ -22,7 +22,6 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
 	"log/slog"
 
 	"github.com/bketelsen/toolbox/cobra"
@@ -30,16 +29,17 @@ import (
 
 // snapshotCmd represents the backup command
 var snapshotCmd = &cobra.Command{
-	Use:   "snapshot",
-	Short: "Create snapshots of instances and volumes",
-	Long:  `Create snapshots of instances and volumes`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:         "snapshot",
+	Short:       "Create snapshots of instances and volumes",
+	Long:        `Create snapshots of instances and volumes`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
 		slog.Info("Snapshotting", slog.String("app", app.Name))
 
-		err := app.Snapshot(cmd.Flag("noexpiry").Changed, cmd.Flag("stateful").Changed, cmd.Flag("volumes").Changed)
-		if err != nil {
-			fmt.Println(err)
+		if dryRun {
+			return printPlan(app.PlanSnapshot(cmd.Context(), boolFlag(cmd, "noexpiry"), boolFlag(cmd, "stateful"), boolFlag(cmd, "volumes")))
 		}
+		return app.Snapshot(cmd.Context(), boolFlag(cmd, "noexpiry"), boolFlag(cmd, "stateful"), boolFlag(cmd, "volumes"))
 	},
 }
 
@@ -49,5 +49,6 @@ func init() {
 	snapshotCmd.Flags().BoolP("noexpiry", "n", false, "No expiry date for the snapshot")
 	snapshotCmd.Flags().BoolP("stateful", "s", false, "Stateful snapshot, if supported")
 	snapshotCmd.Flags().BoolP("volumes", "v", false, "Snapshot volumes")
+	addTimeoutFlag(snapshotCmd)
 
 }
@@ -29,22 +29,32 @@ import (
 
 // startCmd represents the start command
 var startCmd = &cobra.Command{
-	Use:  "start",
-	Args: cobra.MaximumNArgs(1),
+	Use: "start [SERVICE...]",
 
 	Short: "Start instances",
-	Long:  `Start instances`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Start instances
+
+Given services are started together with the services they depend on,
+unless --no-deps is set.`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		slog.Info("Starting", slog.String("app", app.Name))
 
-		err := app.Start(false)
+		services, err := selectServices(cmd, args, app.WithDependencies)
 		if err != nil {
-			slog.Error("Start", slog.String("error", err.Error()))
+			return err
+		}
+
+		if dryRun {
+			return printPlan(app.PlanStart(cmd.Context(), services))
 		}
+		return app.Start(cmd.Context(), services, false)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(startCmd)
+	startCmd.Flags().Bool("no-deps", false, "Don't start linked services")
+	addTimeoutFlag(startCmd)
 }
@@ -0,0 +1,115 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/toolbox/cobra"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the last up or down run",
+	Long: `Show the state of the last up or down run
+
+Reads the journal of the last up or down run and reports whether it
+completed, failed or never finished because it was interrupted, with the
+planned actions it didn't get to. Use 'up --resume' or 'down --resume' to
+continue such a run.
+
+Exits with status 11 when the last run didn't complete.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug("Status", slog.String("app", app.Name))
+
+		complete, err := status(app.ComposeProject.WorkingDir, cmd.Flag("format").Value.String())
+		if err != nil {
+			return err
+		}
+		if !complete {
+			return types.ErrIncomplete
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().String("format", "text", "Output format (text or json)")
+}
+
+// status prints the journal of the last run of the project in dir and
+// reports whether the run completed
+func status(dir, format string) (bool, error) {
+	j, err := application.LoadJournal(dir)
+	if err != nil {
+		return false, err
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return j == nil || j.Complete(), enc.Encode(j)
+	case "text":
+		if j == nil {
+			fmt.Println("No runs recorded")
+			return true, nil
+		}
+		services := "all services"
+		if len(j.Services) > 0 {
+			services = strings.Join(j.Services, ", ")
+		}
+		fmt.Printf("Last run:  %s (%s)\n", j.Command, services)
+		fmt.Printf("State:     %s\n", j.State())
+		fmt.Printf("Started:   %s\n", j.StartedAt.Format(time.RFC3339))
+		if j.FinishedAt != nil {
+			fmt.Printf("Finished:  %s\n", j.FinishedAt.Format(time.RFC3339))
+		}
+		if j.Resumed > 0 {
+			fmt.Printf("Resumed:   %d times\n", j.Resumed)
+		}
+		if j.Error != "" {
+			fmt.Printf("Error:     %s\n", j.Error)
+		}
+		remaining := j.Remaining()
+		fmt.Printf("Completed: %d actions, %d of %d planned actions remaining\n", len(j.Completed), len(remaining), len(j.Planned))
+		if !j.Complete() && len(remaining) > 0 {
+			fmt.Println()
+			err := printPlan(&application.Plan{Actions: remaining}, nil)
+			if err != nil {
+				return false, err
+			}
+		}
+		return j.Complete(), nil
+	default:
+		return false, fmt.Errorf("%w: unsupported format %q", types.ErrUsage, format)
+	}
+}
@@ -22,7 +22,6 @@ THE SOFTWARE.
 package cmd
 
 import (
-	"fmt"
 	"log/slog"
 
 	"github.com/bketelsen/toolbox/cobra"
@@ -30,17 +29,25 @@ import (
 
 // stopCmd represents the stop command
 var stopCmd = &cobra.Command{
-	Use:   "stop",
-	Args:  cobra.MaximumNArgs(1),
+	Use:   "stop [SERVICE...]",
 	Short: "Stop instances",
-	Long:  `Stop instances`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Stop instances
+
+Given services are stopped together with the services that depend on them,
+unless --no-deps is set.`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
 		slog.Info("Stop", slog.String("app", app.Name))
 
-		err := app.Stop(cmd.Flag("stateful").Changed, cmd.Flag("force").Changed, timeout)
+		services, err := selectServices(cmd, args, app.WithDependents)
 		if err != nil {
-			fmt.Println(err)
+			return err
+		}
+
+		if dryRun {
+			return printPlan(app.PlanStop(cmd.Context(), services, boolFlag(cmd, "stateful"), boolFlag(cmd, "force"), timeout))
 		}
+		return app.Stop(cmd.Context(), services, boolFlag(cmd, "stateful"), boolFlag(cmd, "force"), timeout)
 	},
 }
 
@@ -48,6 +55,7 @@ func init() {
 	rootCmd.AddCommand(stopCmd)
 	stopCmd.Flags().BoolP("stateful", "s", false, "Stop stateful instance, if supported")
 	stopCmd.Flags().BoolP("force", "f", false, "Force stop instance")
+	stopCmd.Flags().Bool("no-deps", false, "Don't stop services that depend on the given services")
 	stopCmd.Flags().IntVarP(&timeout, "timeout", "t", -1, "Specify a shutdown timeout in seconds")
 
 }
@@ -22,28 +22,100 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"fmt"
 	"log/slog"
 
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/types"
 	"github.com/bketelsen/toolbox/cobra"
 )
 
 // upCmd represents the up command
 var upCmd = &cobra.Command{
-	Use:   "up",
-	Args:  cobra.MaximumNArgs(1),
+	Use:   "up [SERVICE...]",
 	Short: "Create and start instances",
-	Long:  `Create and start instances`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Create and start instances
+
+Without arguments all services are created and started. Given services are
+created and started together with the services they depend on, unless
+--no-deps is set.
+
+Existing instances are updated in place when their environment, labels,
+ports, devices, profiles or snapshot settings differ from the compose file.
+Changes that can't be applied in place, such as a different storage pool,
+recreate the instance. Use --force-recreate to always recreate instances and
+--no-recreate to never recreate them.
+
+Instances, volumes and networks are labeled with the stack, service and a
+hash of their configuration. With --remove-orphans, instances and networks
+labeled with the stack whose service is no longer in the compose file are
+removed.
+
+With --rollback-on-failure, a failed run deletes the instances, volumes and
+default network it created, detaches the devices it attached to existing
+instances and stops the instances it started. Resources that existed before
+are kept; in-place updates and recreated instances aren't undone.
+
+Every run is recorded in .incus-compose/journal.json. Use --resume to
+continue a run that was interrupted or failed, with the services and options
+it was started with, and 'status' to see how far it got.`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
 
 		slog.Info("Starting", slog.String("app", app.Name))
 
-		err := app.Up()
+		if boolFlag(cmd, "resume") {
+			return resume(cmd, args, "up")
+		}
+
+		services, err := selectServices(cmd, args, app.WithDependencies)
 		if err != nil {
-			slog.Error("Start", slog.String("error", err.Error()))
+			return err
 		}
+
+		if dryRun {
+			return printPlan(app.PlanUp(cmd.Context(), services, recreatePolicy(cmd), boolFlag(cmd, "remove-orphans")))
+		}
+		return app.Up(cmd.Context(), services, recreatePolicy(cmd), boolFlag(cmd, "remove-orphans"), boolFlag(cmd, "rollback-on-failure"))
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(upCmd)
+	upCmd.Flags().Bool("no-deps", false, "Don't start linked services")
+	addTimeoutFlag(upCmd)
+	addRecreateFlags(upCmd)
+	upCmd.Flags().Bool("remove-orphans", false, "Remove instances and networks of services that are no longer in the compose file")
+	upCmd.Flags().Bool("rollback-on-failure", false, "Undo the changes made by this run when it fails")
+	upCmd.Flags().Bool("resume", false, "Continue the last run that didn't complete")
+}
+
+// addRecreateFlags adds the flags that control how existing instances are
+// reconciled with the compose file
+func addRecreateFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("force-recreate", false, "Recreate instances even if their configuration hasn't changed")
+	cmd.Flags().Bool("no-recreate", false, "Don't recreate existing instances, only apply changes that can be made in place")
+	cmd.MarkFlagsMutuallyExclusive("force-recreate", "no-recreate")
+}
+
+// resume continues the interrupted run of command recorded in the journal
+func resume(cmd *cobra.Command, args []string, command string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("%w: --resume continues with the services of the interrupted run, don't give any", types.ErrUsage)
+	}
+	if dryRun {
+		return printPlan(app.PlanResume(cmd.Context(), command))
+	}
+	return app.Resume(cmd.Context(), command)
+}
+
+func recreatePolicy(cmd *cobra.Command) application.RecreatePolicy {
+	switch {
+	case boolFlag(cmd, "force-recreate"):
+		return application.RecreateAlways
+	case boolFlag(cmd, "no-recreate"):
+		return application.RecreateNever
+	default:
+		return application.RecreateChanged
+	}
 }
@@ -29,15 +29,33 @@ import (
 
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
-	Use:   "update",
+	Use:   "update [SERVICE...]",
 	Short: "Rebuild instances from the latest image sources",
-	Long:  `Rebuild instances from the latest image sources`,
-	Run: func(cmd *cobra.Command, args []string) {
-		slog.Info("Updating application instances")
-		slog.Warn("Not implemented")
+	Long: `Rebuild instances from the latest image sources
+
+Each service's image is checked for a newer fingerprint, or a newer digest
+for OCI images. Only instances whose image changed are rebuilt. Custom
+volumes, bind mounts and secrets are kept, and running instances are
+started again after the rebuild.
+
+Checking OCI images requires skopeo to be installed.`,
+	Annotations: map[string]string{lockAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Info("Updating application instances", slog.String("app", app.Name))
+
+		services, err := selectServices(cmd, args, nil)
+		if err != nil {
+			return err
+		}
+
+		if dryRun {
+			return printPlan(app.PlanUpdate(cmd.Context(), services))
+		}
+		return app.Update(cmd.Context(), services)
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(updateCmd)
+	addTimeoutFlag(updateCmd)
 }
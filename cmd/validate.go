@@ -0,0 +1,152 @@
+/*
+Copyright © 2025 Brian Ketelsen <bketelsen@gmail.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/bketelsen/incus-compose/pkg/application"
+	"github.com/bketelsen/incus-compose/pkg/compose"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/toolbox/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the compose file",
+	Long: `Check the compose file
+
+Checks the compose file against the compose specification and the JSON
+Schema of the x-incus extensions, then checks that the values incus-compose
+needs are set and that the project, profiles, storage pools and networks the
+compose file refers to exist on the remote, like up does. With --offline the
+remote isn't contacted, only the static checks run.
+
+Every problem found is printed with its file and line when they're known.
+Invalid compose files exit with status 3 and missing Incus resources with
+status 4.
+
+--write-schema writes the JSON Schema instead, so editors can complete and
+check the x-incus extensions. Point the YAML language server at it with a
+comment at the top of the compose file:
+
+  # yaml-language-server: $schema=incus-compose.schema.json`,
+	Annotations: map[string]string{skipProjectAnnotation: "true"},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		slog.Debug("Validate")
+
+		schema := cmd.Flag("write-schema").Value.String()
+		if schema != "" {
+			return writeSchema(schema)
+		}
+		offline, _ := cmd.Flags().GetBool("offline")
+		return validateProject(cmd, offline)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().Bool("offline", false, "Only run the checks that don't need the remote")
+	validateCmd.Flags().String("write-schema", "", "Write the JSON Schema of the compose file to a file, - for stdout")
+}
+
+// writeSchema writes the JSON Schema to a file, or to stdout for -
+func writeSchema(path string) error {
+	schema, err := application.Schema()
+	if err != nil {
+		return err
+	}
+	schema = append(schema, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(schema)
+		return err
+	}
+	err = os.WriteFile(path, schema, 0o644)
+	if err != nil {
+		return err
+	}
+	slog.Info("Schema written", slog.String("file", path))
+	return nil
+}
+
+// validateProject loads the compose file and prints the problems found in it
+func validateProject(cmd *cobra.Command, offline bool) error {
+	err := checkProject(cmd.Context(), configureLoader(cmd), offline)
+	if err != nil && (errors.Is(err, types.ErrComposeFile) || errors.Is(err, types.ErrSanityCheck)) {
+		problems := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			problems = joined.Unwrap()
+		}
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+		return &invalidError{err: err, problems: len(problems)}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("The compose file is valid")
+	return nil
+}
+
+// checkProject runs the checks one kind after the other, as the later ones
+// would report the problems found by the earlier ones again
+func checkProject(ctx context.Context, loader compose.Loader, offline bool) error {
+	project, err := loader.LoadProject(ctx)
+	if err != nil {
+		return err
+	}
+	model, err := loader.LoadModel(ctx)
+	if err != nil {
+		return err
+	}
+	err = application.ValidateModel(model, project.ComposeFiles)
+	if err != nil {
+		return err
+	}
+
+	a, err := application.BuildDirect(project, conf)
+	if err != nil {
+		return err
+	}
+	return a.Validate(ctx, offline)
+}
+
+// invalidError reports the problems printed by validate, keeping their kinds
+// for the exit code
+type invalidError struct {
+	err      error
+	problems int
+}
+
+func (e *invalidError) Error() string {
+	return fmt.Sprintf("found %d problem(s)", e.problems)
+}
+
+func (e *invalidError) Unwrap() error { return e.err }
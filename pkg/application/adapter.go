@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"log/slog"
+	"strconv"
 
 	"github.com/compose-spec/compose-go/v2/types"
 	"github.com/gosimple/slug"
@@ -19,34 +20,22 @@ func BuildDirect(p *types.Project, conf *cliconfig.Config) (*Compose, error) {
 	compose.Name = p.Name
 	compose.Project = "default"
 	compose.conf = conf
+	compose.Retry = DefaultRetry
+
+	dec := newExtensionDecoder(p.ComposeFiles)
 
 	// parse extensions
-	for k, v := range p.Extensions {
-		switch k {
-		case "x-incus-default-profiles":
-			list, ok := v.([]interface{})
-			if ok {
-				for _, profile := range list {
-					p := profile.(string)
-					compose.Profiles = append(compose.Profiles, p)
-				}
-			}
-			continue
-		case "x-incus-project":
-			proj, ok := v.(string)
-			if ok {
-				compose.Project = proj
-			}
-			continue
-		default:
-			slog.Error("unsupported compose extension", "project", p.Name, "extension", k)
-		}
+	var ext ProjectExtensions
+	dec.decode(p.Extensions, &ext)
+	compose.Profiles = ext.DefaultProfiles
+	if ext.Project != "" {
+		compose.Project = ext.Project
 	}
 
 	// parse services
 	compose.Services = make(map[string]Service)
 	for _, s := range p.Services {
-		service := parseService(s)
+		service := parseService(dec, s)
 		compose.Services[s.Name] = service
 	}
 
@@ -58,36 +47,11 @@ func BuildDirect(p *types.Project, conf *cliconfig.Config) (*Compose, error) {
 	}
 
 	// get additional information about volumes
-	for _, vol := range p.Volumes {
-		var snap *Snapshot
-
-		// parse volume extensions
-		for k, v := range vol.Extensions {
-			switch k {
-			case "x-incus-snapshot":
-				snapshot, ok := v.(map[string]interface{})
-				if ok {
-					snap = &Snapshot{}
-					for k, v := range snapshot {
-						switch k {
-						case "schedule":
-							snap.Schedule = v.(string)
-						case "expiry":
-							snap.Expiry = v.(string)
-						case "pattern":
-							snap.Pattern = v.(string)
-						default:
-							slog.Error("unsupported snapshot extension", "volume", vol.Name, "extension", k)
-						}
-					}
-					//service.Snapshot = snap
+	for name, vol := range p.Volumes {
+		var ext VolumeExtensions
+		dec.decode(vol.Extensions, &ext, "volumes", name)
+		snap := ext.Snapshot
 
-				}
-				continue
-			default:
-				slog.Error("unsupported compose extension", "volume", vol.Name, "extension", k)
-			}
-		}
 		// now find the service that uses this volume
 		for _, s := range compose.Services {
 			for k, v := range s.Volumes {
@@ -106,9 +70,15 @@ func BuildDirect(p *types.Project, conf *cliconfig.Config) (*Compose, error) {
 			}
 		}
 	}
+
+	err := dec.err()
+	if err != nil {
+		return nil, err
+	}
 	return compose, nil
 }
-func parseService(s types.ServiceConfig) Service {
+
+func parseService(dec *extensionDecoder, s types.ServiceConfig) Service {
 	service := Service{}
 	for dep := range s.DependsOn {
 		service.DependsOn = append(service.DependsOn, dep)
@@ -120,75 +90,23 @@ func parseService(s types.ServiceConfig) Service {
 	}
 
 	// parse service extensions
-	for k, v := range s.Extensions {
-		switch k {
-		case "x-incus-additional-profiles":
-			list, ok := v.([]interface{})
-			if ok {
-				for _, profile := range list {
-					p := profile.(string)
-					service.AdditionalProfiles = append(service.AdditionalProfiles, p)
-				}
-			}
-			continue
-		case "x-incus-cloud-init-user-data-file":
-			df, ok := v.(string)
-			if ok {
-				service.CloudInitUserDataFile = df
-			}
-			continue
-		case "x-incus-storage":
-			pool, ok := v.(string)
-			if ok {
-				service.Storage = pool
-			}
-			continue
-		case "x-incus-gpu":
-			gpu, ok := v.(bool)
-			if ok {
-				service.GPU = gpu
-			}
-			continue
-		case "x-incus-snapshot":
-			snapshot, ok := v.(map[string]interface{})
-			if ok {
-				snap := &Snapshot{}
-				for k, v := range snapshot {
-					switch k {
-					case "schedule":
-						snap.Schedule = v.(string)
-					case "expiry":
-						snap.Expiry = v.(string)
-					case "pattern":
-						snap.Pattern = v.(string)
-					default:
-						slog.Error("unsupported snapshot extension", "service", s.Name, "extension", k)
-					}
-				}
-				service.Snapshot = snap
+	var ext ServiceExtensions
+	dec.decode(s.Extensions, &ext, "services", s.Name)
+	service.AdditionalProfiles = ext.AdditionalProfiles
+	service.CloudInitUserDataFile = ext.CloudInitUserDataFile
+	service.Storage = ext.Storage
+	service.GPU = ext.GPU
+	service.Snapshot = ext.Snapshot
 
-			}
-			continue
-		default:
-			slog.Error("unsupported compose extension", "service", s.Name, "extension", k)
-		}
-	}
 	service.Volumes = make(map[string]*Volume)
 	service.BindMounts = make(map[string]Bind)
 
 	// parse volumes
-	for _, v := range s.Volumes {
+	for i, v := range s.Volumes {
 
-		var shifted bool = false
-		for key, val := range v.Extensions {
-			switch key {
-			case "x-incus-shift":
-				shifted = val.(bool)
-				continue
-			default:
-				slog.Error("unsupported compose extension", "volume", v.Source, "extension", key)
-			}
-		}
+		var mount MountExtensions
+		dec.decode(v.Extensions, &mount, "services", s.Name, "volumes", strconv.Itoa(i))
+		shifted := mount.Shift
 
 		switch v.Type {
 		case "volume":
@@ -208,16 +126,6 @@ func parseService(s types.ServiceConfig) Service {
 			if shifted {
 				bind.Shift = shifted
 			}
-
-			for key, val := range v.Extensions {
-				switch key {
-				case "x-incus-shift":
-					bind.Shift = val.(bool)
-					continue
-				default:
-					slog.Error("unsupported compose extension", "volume", v.Source, "extension", key)
-				}
-			}
 			service.BindMounts[bindNameStable(v.Source)] = bind
 		default:
 			slog.Error("unsupported volume type", "service", s.Name, "volume", v.Source, "type", v.Type)
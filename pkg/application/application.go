@@ -2,8 +2,10 @@ package application
 
 import (
 	"log/slog"
+	"maps"
 	"slices"
 
+	"github.com/bketelsen/incus-compose/pkg/types"
 	"github.com/dominikbraun/graph"
 )
 
@@ -37,3 +39,74 @@ func (app *Compose) Order(reverse bool) []string {
 	}
 	return []string{}
 }
+
+// WithDependencies returns the given services together with all services they
+// depend on, directly or indirectly.
+func (app *Compose) WithDependencies(services []string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, service := range services {
+		if _, ok := app.Services[service]; !ok {
+			return nil, &types.NotFoundError{Kind: "service", Name: service}
+		}
+		if app.Dag == nil {
+			selected[service] = true
+			continue
+		}
+		// edges point from a service to its dependencies
+		err := graph.DFS(app.Dag, service, func(s string) bool {
+			selected[s] = true
+			return false
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return slices.Sorted(maps.Keys(selected)), nil
+}
+
+// WithDependents returns the given services together with all services that
+// depend on them, directly or indirectly.
+func (app *Compose) WithDependents(services []string) ([]string, error) {
+	selected := map[string]bool{}
+	queue := slices.Clone(services)
+	for len(queue) > 0 {
+		service := queue[0]
+		queue = queue[1:]
+		if selected[service] {
+			continue
+		}
+		if _, ok := app.Services[service]; !ok {
+			return nil, &types.NotFoundError{Kind: "service", Name: service}
+		}
+		selected[service] = true
+
+		dependents, err := app.DependentsForService(service)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, dependents...)
+	}
+	return slices.Sorted(maps.Keys(selected)), nil
+}
+
+// OrderFor returns the given services in the order they should be started or
+// stopped, see Order. All services are returned when none are given.
+func (app *Compose) OrderFor(services []string, reverse bool) []string {
+	order := app.Order(reverse)
+	if len(services) == 0 {
+		return order
+	}
+	return slices.DeleteFunc(order, func(s string) bool {
+		return !slices.Contains(services, s)
+	})
+}
+
+// allServices reports whether the selection covers the whole stack
+func (app *Compose) allServices(services []string) bool {
+	for name := range app.Services {
+		if len(services) > 0 && !slices.Contains(services, name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,294 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/compose"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	composetypes "github.com/compose-spec/compose-go/v2/types"
+	"github.com/dominikbraun/graph"
+	api "github.com/lxc/incus/v6/shared/api"
+	cliconfig "github.com/lxc/incus/v6/shared/cliconfig"
+)
+
+const testCompose = `
+name: shop
+services:
+  db:
+    image: alpine
+    volumes:
+      - data:/var/lib/db
+  web:
+    image: alpine
+    depends_on:
+      - db
+volumes:
+  data: {}
+`
+
+// loadTestProject writes a compose file to a temporary directory, which
+// becomes the working directory, and loads it
+func loadTestProject(t *testing.T, yaml string) *composetypes.Project {
+	t.Helper()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "compose.yaml"), []byte(yaml), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// secrets are copied relative to the working directory
+	t.Chdir(dir)
+
+	project, err := compose.NewLoaderWithOptions(compose.LoaderOptions{WorkingDir: dir}).LoadProject(context.Background())
+	if err != nil {
+		t.Fatalf("load project: %v", err)
+	}
+	return project
+}
+
+// newTestApp loads a compose file in a temporary directory and connects the
+// application to a fake Incus server
+func newTestApp(t *testing.T, yaml string) (*Compose, *fakeIncus) {
+	t.Helper()
+
+	app, err := BuildDirect(loadTestProject(t, yaml), cliconfig.NewConfig("", true))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	g := graph.New(graph.StringHash, graph.Directed(), graph.Acyclic())
+	for name := range app.Services {
+		_ = g.AddVertex(name)
+	}
+	for name := range app.Services {
+		for _, dep := range app.Services[name].DependsOn {
+			_ = g.AddEdge(name, dep)
+		}
+	}
+	app.Dag = g
+
+	fake := newFakeIncus()
+	app.connect = fake.connect
+	app.ExportPath = t.TempDir()
+	app.Retry = Retry{Attempts: 3, Backoff: time.Millisecond}
+	return app, fake
+}
+
+func TestUp(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+
+	err := app.Up(context.Background(), nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	if got := fake.called("CreateNetwork"); !slices.Equal(got, []string{"CreateNetwork shop"}) {
+		t.Errorf("networks created: %v", got)
+	}
+	if got := fake.called("CreateStoragePoolVolume"); !slices.Equal(got, []string{"CreateStoragePoolVolume default shop-db-data"}) {
+		t.Errorf("volumes created: %v", got)
+	}
+	// db is created before web, which depends on it
+	if got := fake.called("CreateInstance"); !slices.Equal(got, []string{"CreateInstance db", "CreateInstance web"}) {
+		t.Errorf("instances created: %v", got)
+	}
+
+	for _, name := range []string{"db", "web"} {
+		inst := fake.instance(name)
+		if inst == nil {
+			t.Fatalf("instance %s not created", name)
+		}
+		if inst.StatusCode != api.Running {
+			t.Errorf("instance %s is %s, want running", name, inst.Status)
+		}
+		if inst.Config[stackKey] != "shop" {
+			t.Errorf("instance %s isn't labelled with the stack: %v", name, inst.Config)
+		}
+	}
+	dev, ok := fake.instance("db").Devices["shop-db-data"]
+	if !ok || dev["source"] != "shop-db-data" || dev["path"] != "/var/lib/db" {
+		t.Errorf("volume not attached to db: %v", fake.instance("db").Devices)
+	}
+
+	// a second run leaves everything in place
+	err = app.Up(context.Background(), nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("second up: %v", err)
+	}
+	if got := fake.called("CreateInstance"); len(got) != 2 {
+		t.Errorf("instances created again: %v", got)
+	}
+}
+
+func TestUpRetriesChangedInstance(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+	fake.failNext("UpdateInstance", api.StatusErrorf(http.StatusPreconditionFailed, "changed"))
+
+	err := app.Up(context.Background(), nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if len(fake.failures["UpdateInstance"]) != 0 {
+		t.Fatal("instance never updated")
+	}
+	if _, ok := fake.instance("db").Devices["shop-db-data"]; !ok {
+		t.Errorf("volume not attached after a retry: %v", fake.instance("db").Devices)
+	}
+}
+
+func TestUpRollsBackSubmittedInstance(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+	// db is made but creating it doesn't complete
+	fake.failWait("CreateInstance", errors.New("image download failed"))
+
+	err := app.Up(context.Background(), nil, RecreateNever, false, true)
+	if err == nil {
+		t.Fatal("up succeeded")
+	}
+	if got := fake.called("DeleteInstance"); !slices.Equal(got, []string{"DeleteInstance db"}) {
+		t.Errorf("instances deleted: %v", got)
+	}
+	if got := fake.called("DeleteStoragePoolVolume"); !slices.Equal(got, []string{"DeleteStoragePoolVolume default shop-db-data"}) {
+		t.Errorf("volumes deleted: %v", got)
+	}
+	if got := fake.called("DeleteNetwork"); !slices.Equal(got, []string{"DeleteNetwork shop"}) {
+		t.Errorf("networks deleted: %v", got)
+	}
+	if len(fake.instances) != 0 || len(fake.volumes) != 0 || len(fake.networks) != 0 {
+		t.Errorf("left after rollback: %d instances, %d volumes, %d networks", len(fake.instances), len(fake.volumes), len(fake.networks))
+	}
+}
+
+func TestDown(t *testing.T) {
+	for _, volumes := range []bool{false, true} {
+		t.Run(map[bool]string{false: "keep volumes", true: "volumes"}[volumes], func(t *testing.T) {
+			app, fake := newTestApp(t, testCompose)
+			err := app.Up(context.Background(), nil, RecreateNever, false, false)
+			if err != nil {
+				t.Fatalf("up: %v", err)
+			}
+
+			err = app.Down(context.Background(), false, volumes, false, -1)
+			if err != nil {
+				t.Fatalf("down: %v", err)
+			}
+
+			// web is removed before db, which it depends on
+			if got := fake.called("DeleteInstance"); !slices.Equal(got, []string{"DeleteInstance web", "DeleteInstance db"}) {
+				t.Errorf("instances deleted: %v", got)
+			}
+			if got := fake.called("DeleteNetwork"); !slices.Equal(got, []string{"DeleteNetwork shop"}) {
+				t.Errorf("networks deleted: %v", got)
+			}
+			deleted := len(fake.called("DeleteStoragePoolVolume")) == 1
+			if deleted != volumes {
+				t.Errorf("volume deleted: %t, want %t", deleted, volumes)
+			}
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+	err := app.Up(context.Background(), nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	err = app.Snapshot(context.Background(), false, false, true)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	if got := fake.called("CreateInstanceSnapshot"); len(got) != 2 {
+		t.Errorf("instance snapshots: %v", got)
+	}
+	if got := fake.called("CreateStoragePoolVolumeSnapshot"); len(got) != 1 {
+		t.Errorf("volume snapshots: %v", got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+	err := app.Up(context.Background(), nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	err = app.Export(context.Background(), false, false)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(app.ExportPath, "*-export-*.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("export files: %v", files)
+	}
+	// the backups are deleted once downloaded
+	if got := fake.called("DeleteInstanceBackup"); len(got) != 2 {
+		t.Errorf("backups deleted: %v", got)
+	}
+}
+
+func TestSanityCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		setup func(f *fakeIncus)
+		step  string
+	}{
+		{
+			name: "ok",
+			yaml: testCompose,
+		},
+		{
+			name: "missing project",
+			yaml: testCompose + "x-incus-project: shop\n",
+			step: "check declared project exists",
+		},
+		{
+			name: "missing profile",
+			yaml: testCompose + "x-incus-default-profiles:\n  - default\n  - gpu\n",
+			step: "check declared profile exists",
+		},
+		{
+			name:  "missing pool",
+			yaml:  testCompose,
+			setup: func(f *fakeIncus) { f.pools = []string{"fast"} },
+			step:  "check declared volume storage pool exists",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake := newTestApp(t, tt.yaml)
+			if tt.setup != nil {
+				tt.setup(fake)
+			}
+
+			err := app.SanityCheck(context.Background())
+			if tt.step == "" {
+				if err != nil {
+					t.Fatalf("sanity check: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, types.ErrSanityCheck) {
+				t.Fatalf("error %v isn't a sanity check error", err)
+			}
+			var sanityErr *types.SanityCheckError
+			if !errors.As(err, &sanityErr) || sanityErr.Step != tt.step {
+				t.Errorf("failed step: %v, want %q", err, tt.step)
+			}
+		})
+	}
+}
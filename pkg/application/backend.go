@@ -0,0 +1,122 @@
+package application
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	incus "github.com/lxc/incus/v6/client"
+	api "github.com/lxc/incus/v6/shared/api"
+	config "github.com/lxc/incus/v6/shared/cliconfig"
+)
+
+// Backend is the part of the Incus API incus-compose uses: instances,
+// volumes, networks, backups, snapshots and exec, plus the projects,
+// profiles and pools the sanity check and the stack lock look at.
+// incusBackend talks to an Incus server, tests use an in-memory fake.
+type Backend interface {
+	ImageSource
+
+	UseProject(name string) Backend
+	HasExtension(extension string) bool
+
+	GetInstances(instanceType api.InstanceType) ([]api.Instance, error)
+	GetInstancesAllProjects(instanceType api.InstanceType) ([]api.Instance, error)
+	GetInstance(name string) (*api.Instance, string, error)
+	GetInstanceState(name string) (*api.InstanceState, string, error)
+	CreateInstanceFromImage(source ImageSource, image api.Image, req api.InstancesPost) (incus.RemoteOperation, error)
+	RebuildInstanceFromImage(source ImageSource, image api.Image, instanceName string, req api.InstanceRebuildPost) (incus.RemoteOperation, error)
+	UpdateInstance(name string, instance api.InstancePut, ETag string) (incus.Operation, error)
+	UpdateInstanceState(name string, state api.InstanceStatePut, ETag string) (incus.Operation, error)
+	DeleteInstance(name string) (incus.Operation, error)
+	ExecInstance(instanceName string, exec api.InstanceExecPost, args *incus.InstanceExecArgs) (incus.Operation, error)
+	GetInstanceConsoleLog(instanceName string, args *incus.InstanceConsoleLogArgs) (io.ReadCloser, error)
+
+	CreateInstanceSnapshot(instanceName string, snapshot api.InstanceSnapshotsPost) (incus.Operation, error)
+	CreateInstanceBackup(instanceName string, backup api.InstanceBackupsPost) (incus.Operation, error)
+	DeleteInstanceBackup(instanceName string, name string) (incus.Operation, error)
+	GetInstanceBackupFile(instanceName string, name string, req *incus.BackupFileRequest) (*incus.BackupFileResponse, error)
+
+	GetStoragePoolNames() ([]string, error)
+	GetStoragePool(name string) (*api.StoragePool, string, error)
+	GetStoragePoolVolumes(pool string) ([]api.StorageVolume, error)
+	GetStoragePoolVolume(pool string, volType string, name string) (*api.StorageVolume, string, error)
+	CreateStoragePoolVolume(pool string, volume api.StorageVolumesPost) error
+	UpdateStoragePoolVolume(pool string, volType string, name string, volume api.StorageVolumePut, ETag string) error
+	DeleteStoragePoolVolume(pool string, volType string, name string) error
+	CreateStoragePoolVolumeSnapshot(pool string, volumeType string, volumeName string, snapshot api.StorageVolumeSnapshotsPost) (incus.Operation, error)
+	CreateStorageVolumeBackup(pool string, volName string, backup api.StorageVolumeBackupsPost) (incus.Operation, error)
+	DeleteStorageVolumeBackup(pool string, volName string, name string) (incus.Operation, error)
+	GetStorageVolumeBackupFile(pool string, volName string, name string, req *incus.BackupFileRequest) (*incus.BackupFileResponse, error)
+
+	GetNetworkNames() ([]string, error)
+	GetNetworks() ([]api.Network, error)
+	GetNetwork(name string) (*api.Network, string, error)
+	CreateNetwork(network api.NetworksPost) error
+	DeleteNetwork(name string) error
+
+	GetProjectNames() ([]string, error)
+	GetProject(name string) (*api.Project, string, error)
+	UpdateProject(name string, project api.ProjectPut, ETag string) error
+	GetProfileNames() ([]string, error)
+}
+
+// ImageSource is the part of an image server used to resolve images
+type ImageSource interface {
+	GetImage(fingerprint string) (*api.Image, string, error)
+	GetImageAlias(name string) (*api.ImageAliasesEntry, string, error)
+}
+
+// incusBackend is the Backend of an Incus server
+type incusBackend struct {
+	incus.InstanceServer
+}
+
+func (b *incusBackend) UseProject(name string) Backend {
+	return &incusBackend{b.InstanceServer.UseProject(name)}
+}
+
+func (b *incusBackend) CreateInstanceFromImage(source ImageSource, image api.Image, req api.InstancesPost) (incus.RemoteOperation, error) {
+	server, err := imageServer(source)
+	if err != nil {
+		return nil, err
+	}
+	return b.InstanceServer.CreateInstanceFromImage(server, image, req)
+}
+
+func (b *incusBackend) RebuildInstanceFromImage(source ImageSource, image api.Image, instanceName string, req api.InstanceRebuildPost) (incus.RemoteOperation, error) {
+	server, err := imageServer(source)
+	if err != nil {
+		return nil, err
+	}
+	return b.InstanceServer.RebuildInstanceFromImage(server, image, instanceName, req)
+}
+
+// imageServer returns the image server behind an image source
+func imageServer(source ImageSource) (incus.ImageServer, error) {
+	switch s := source.(type) {
+	case *incusBackend:
+		return s.InstanceServer, nil
+	case incus.ImageServer:
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unsupported image source %T", source)
+	}
+}
+
+// connectIncus connects to a remote of the Incus configuration
+func connectIncus(conf *config.Config, remote string) (Backend, error) {
+	d, err := conf.GetInstanceServer(remote)
+	if err != nil {
+		return nil, &types.IncusError{Remote: remote, Err: err}
+	}
+	return &incusBackend{d}, nil
+}
+
+// backend connects to a remote
+func (app *Compose) backend(remote string) (Backend, error) {
+	if app.connect != nil {
+		return app.connect(remote)
+	}
+	return connectIncus(app.conf, remote)
+}
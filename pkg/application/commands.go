@@ -1,229 +1,218 @@
 package application
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
-	"strings"
 
+	"github.com/bketelsen/incus-compose/pkg/types"
 	"github.com/bketelsen/incus-compose/pkg/ui"
 )
 
 // keep all the external commands in one place
-func (app *Compose) Up() error {
-	err := app.SanityCheck()
-	if err != nil {
-		return err
-	}
-	err = app.CreateDefaultNetwork("")
-	if err != nil {
-		return err
-	}
 
-	for _, service := range app.Order(true) {
+// Up creates and starts the instances of the given services, or of all
+// services when none are given. With removeOrphans, instances and networks
+// of the stack that aren't declared anymore are removed first. With
+// rollbackOnFailure, everything created and started by a failed run is
+// undone. The run is recorded in the journal so it can be resumed.
+func (app *Compose) Up(ctx context.Context, services []string, policy RecreatePolicy, removeOrphans, rollbackOnFailure bool) error {
+	j := app.newJournal("up", services, JournalOptions{
+		Policy:            policy,
+		RemoveOrphans:     removeOrphans,
+		RollbackOnFailure: rollbackOnFailure,
+	})
+	plan := func() (*Plan, error) {
+		return app.PlanUp(ctx, services, policy, removeOrphans)
+	}
+	return app.journaled(j, plan, func() error {
+		return app.up(ctx, services, policy, removeOrphans, rollbackOnFailure)
+	})
+}
 
-		err := app.InitContainerForService(service)
+func (app *Compose) up(ctx context.Context, services []string, policy RecreatePolicy, removeOrphans, rollbackOnFailure bool) error {
+	if removeOrphans {
+		err := app.RemoveOrphans(ctx, false)
 		if err != nil {
 			return err
 		}
+	}
 
-		err = app.CreateVolumesForService(service)
+	up := func() error {
+		err := app.Create(ctx, services, policy)
 		if err != nil {
 			return err
 		}
+		return app.Start(ctx, services, true)
+	}
+	if rollbackOnFailure {
+		return app.withRollback(ctx, up)
+	}
+	return up()
+}
 
-		err = app.CreateBindsForService(service)
-		if err != nil {
-			return err
-		}
+// Create provisions the default network and the instances, volumes, bind
+// mounts and secrets of the given services, or of all services when none are
+// given, leaving new instances stopped. Existing instances are reconciled
+// with the compose file according to the policy.
+func (app *Compose) Create(ctx context.Context, services []string, policy RecreatePolicy) error {
+	err := app.SanityCheck(ctx)
+	if err != nil {
+		return err
+	}
+	network, err := app.createNetworkActions()
+	if err != nil {
+		return err
+	}
+	err = app.applyActions(ctx, network)
+	if err != nil {
+		return err
+	}
 
-		err = app.AttachVolumesForService(service)
+	return app.runLevels(ctx, services, false, func(ctx context.Context, service string) error {
+		actions, err := app.createActions(service, false, policy)
 		if err != nil {
 			return err
 		}
+		return app.applyActions(ctx, actions)
+	})
+}
 
-		err = app.StartContainerForService(service, true)
-		if err != nil {
-			return err
-		}
+// Update rebuilds the instances of the given services, or of all services
+// when none are given, whose image has changed.
+func (app *Compose) Update(ctx context.Context, services []string) error {
+	err := app.SanityCheck(ctx)
+	if err != nil {
+		return err
+	}
 
-		err = app.CreateSecretsForService(service)
-		if err != nil {
-			return err
-		}
+	return app.runLevels(ctx, services, false, app.UpdateContainerForService)
+}
 
-	}
-	return nil
+func (app *Compose) Stop(ctx context.Context, services []string, stateful, force bool, timeout int) error {
+	return app.runLevels(ctx, services, true, func(ctx context.Context, service string) error {
+		return app.StopContainerForService(ctx, service, stateful, force, timeout)
+	})
 }
 
-func (app *Compose) Stop(stateful, force bool, timeout int) error {
-	for _, service := range app.Order(false) {
+// Down stops and removes all instances and the default network, and the
+// custom volumes when volumes is set. With removeOrphans, resources of the
+// stack that aren't declared anymore are removed first. The run is recorded
+// in the journal so it can be resumed.
+func (app *Compose) Down(ctx context.Context, force, volumes, removeOrphans bool, timeout int) error {
+	j := app.newJournal("down", nil, JournalOptions{
+		Force:         force,
+		Volumes:       volumes,
+		RemoveOrphans: removeOrphans,
+		Timeout:       timeout,
+	})
+	plan := func() (*Plan, error) {
+		return app.PlanDown(ctx, force, volumes, removeOrphans, timeout)
+	}
+	return app.journaled(j, plan, func() error {
+		return app.down(ctx, force, volumes, removeOrphans, timeout)
+	})
+}
 
-		err := app.StopContainerForService(service, stateful, force, timeout)
+func (app *Compose) down(ctx context.Context, force, volumes, removeOrphans bool, timeout int) error {
+	if removeOrphans {
+		err := app.RemoveOrphans(ctx, volumes)
 		if err != nil {
 			return err
 		}
-
 	}
-	return nil
-}
-
-func (app *Compose) Down(force, volumes bool, timeout int) error {
-	for _, service := range app.Order(false) {
 
-		err := app.StopContainerForService(service, false, force, timeout)
+	err := app.runLevels(ctx, nil, true, func(ctx context.Context, service string) error {
+		actions, err := app.removeActions(service, force, force, true, volumes, timeout)
 		if err != nil {
 			return err
 		}
-		err = app.RemoveContainerForService(service, force)
+		err = app.applyActions(ctx, actions)
+		if err != nil || volumes {
+			return err
+		}
+		vols, err := app.ListVolumesForService(service)
 		if err != nil {
 			return err
 		}
-		if volumes {
-			err = app.DeleteVolumesForService(service)
-			if err != nil {
-				return err
-			}
-		} else {
-			vols, err := app.ListVolumesForService(service)
-			if err != nil {
-				return err
-			}
-			if len(vols) > 0 {
-				for _, vol := range vols {
-					slog.Warn("Volume not deleted", slog.String("instance", service), slog.String("volume", fmt.Sprintf("%v", vol)))
-				}
-			}
+		for _, vol := range vols {
+			slog.Warn("Volume not deleted", slog.String("instance", service), slog.String("volume", vol))
 		}
-
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	err := app.DestroyDefaultNetwork()
+	network, err := app.destroyNetworkActions(nil)
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return app.applyActions(ctx, network)
 }
-func (app *Compose) Snapshot(noexpiry, stateful, volumes bool) error {
-	for _, service := range app.Order(false) {
-		slog.Info("Instance snapshot start", slog.String("instance", service))
-		err := app.SnapshotInstance(service, noexpiry, stateful, volumes)
+
+func (app *Compose) Snapshot(ctx context.Context, noexpiry, stateful, volumes bool) error {
+	return app.runLevels(ctx, nil, true, func(ctx context.Context, service string) error {
+		actions, err := app.snapshotActions(service, noexpiry, stateful, volumes)
 		if err != nil {
 			return err
 		}
-		slog.Info("Instance snapshot complete", slog.String("instance", service))
-		if volumes {
-			for volName, vol := range app.Services[service].Volumes {
-				slog.Info("Volume snapshot start", slog.String("volume", vol.CreateName(app.Name, service, volName)))
-				err := app.SnapshotVolume(vol.Pool, vol.CreateName(app.Name, service, volName), noexpiry, stateful, volumes)
-				if err != nil {
-					return err
-				}
-				slog.Info("Volume snapshot complete", slog.String("volume", vol.CreateName(app.Name, service, volName)))
-			}
-		}
-
-	}
-	return nil
+		return app.applyActions(ctx, actions)
+	})
 }
 
-func (app *Compose) Export(volumes bool, customVolumesOnly bool) error {
+func (app *Compose) Export(ctx context.Context, volumes bool, customVolumesOnly bool) error {
 	slog.Info("Export Root", slog.String("path", app.ExportPath))
 
-	for _, service := range app.Order(false) {
-		if !customVolumesOnly {
-			slog.Info("Instance export start", slog.String("instance", service))
-			err := app.ExportInstance(service, volumes)
-			if err != nil {
-				return err
-			}
-			slog.Info("Instance export complete", slog.String("instance", service))
-		}
-		if customVolumesOnly {
-			for volName, vol := range app.Services[service].Volumes {
-				slog.Info("Volume export start", slog.String("volume", vol.CreateName(app.Name, service, volName)))
-				err := app.ExportVolume(vol.Pool, vol.CreateName(app.Name, service, volName))
-				if err != nil {
-					return err
-				}
-				slog.Info("Volume export complete", slog.String("volume", vol.CreateName(app.Name, service, volName)))
-			}
-		}
-
-	}
-	return nil
-}
-
-func (app *Compose) Start(wait bool) error {
-	for _, service := range app.Order(true) {
-
-		err := app.StartContainerForService(service, wait)
+	return app.runLevels(ctx, nil, true, func(ctx context.Context, service string) error {
+		actions, err := app.exportActions(service, volumes, customVolumesOnly)
 		if err != nil {
 			return err
 		}
+		return app.applyActions(ctx, actions)
+	})
+}
 
-	}
-	return nil
+func (app *Compose) Start(ctx context.Context, services []string, wait bool) error {
+	return app.runLevels(ctx, services, false, func(ctx context.Context, service string) error {
+		return app.StartContainerForService(ctx, service, wait)
+	})
 }
 
-func (app *Compose) Restart() error {
-	for _, service := range app.Order(true) {
+func (app *Compose) Restart(ctx context.Context, services []string) error {
+	return app.runLevels(ctx, services, false, app.RestartContainerForService)
+}
 
-		err := app.RestartContainerForService(service)
+func (app *Compose) Remove(ctx context.Context, services []string, timeout int, force, stop, volumes bool) error {
+	err := app.runLevels(ctx, services, true, func(ctx context.Context, service string) error {
+		actions, err := app.removeActions(service, true, force, stop, volumes, timeout)
 		if err != nil {
 			return err
 		}
-
+		return app.applyActions(ctx, actions)
+	})
+	if err != nil {
+		return err
 	}
-	return nil
-}
-
-func (app *Compose) Remove(timeout int, force, stop, volumes bool) error {
-	for _, service := range app.Order(false) {
-
-		if stop {
-			err := app.StopContainerForService(service, false, true, timeout)
-			if err != nil {
-				if strings.Contains(err.Error(), "already stopped") {
-					slog.Info("Instance already stopped", slog.String("instance", service))
-				} else {
-					return err
-				}
-			}
-		}
-		err := app.RemoveContainerForService(service, force)
-		if err != nil {
-			if strings.Contains(err.Error(), "running") {
-				slog.Error("Instance currently running", slog.String("instance", service))
-				slog.Error("Stop it first or use --force", slog.String("instance", service))
-				return err
-			} else {
-				return err
-			}
-		}
-		if volumes {
-			err = app.DeleteVolumesForService(service)
-			if err != nil {
-				return err
-			}
-		}
 
-	}
-	err := app.DestroyDefaultNetwork()
+	// the default network is still in use when only some services are removed
+	network, err := app.destroyNetworkActions(services)
 	if err != nil {
 		return err
 	}
-	return nil
+	return app.applyActions(ctx, network)
 }
 
-func (app *Compose) Info() error {
+func (app *Compose) Info(ctx context.Context, services []string) error {
 
 	instanceMap := make(map[string]ui.InstanceDetails)
 
-	for service := range app.Services {
+	for _, service := range app.OrderFor(services, true) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		svc, ok := app.Services[service]
 		if !ok {
-			return fmt.Errorf("service %s not found", service)
+			return &types.NotFoundError{Kind: "service", Name: service}
 		}
 
 		containerName := svc.GetContainerName()
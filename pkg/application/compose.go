@@ -1,11 +1,11 @@
 package application
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"strings"
 
-	incus "github.com/lxc/incus/v6/client"
 	"gopkg.in/yaml.v3"
 )
 
@@ -28,16 +28,16 @@ func (c *Compose) DependentsForService(s string) ([]string, error) {
 	return c.ComposeProject.GetDependentsForService(sc), nil
 
 }
-func (c *Compose) StopService(s string, stateful, force bool, timeout int) error {
-	return c.StopContainerForService(s, stateful, force, timeout)
+func (c *Compose) StopService(ctx context.Context, s string, stateful, force bool, timeout int) error {
+	return c.StopContainerForService(ctx, s, stateful, force, timeout)
 }
-func (c *Compose) StartService(s string, wait bool) error {
-	return c.StartContainerForService(s, wait)
+func (c *Compose) StartService(ctx context.Context, s string, wait bool) error {
+	return c.StartContainerForService(ctx, s, wait)
 }
-func (c *Compose) StopAll(stateful, force bool, timeout int) error {
+func (c *Compose) StopAll(ctx context.Context, stateful, force bool, timeout int) error {
 	ss := c.ListServices()
 	for _, s := range ss {
-		err := c.StopContainerForService(s, stateful, force, timeout)
+		err := c.StopContainerForService(ctx, s, stateful, force, timeout)
 		if err != nil {
 			if strings.Contains(err.Error(), "already stopped") {
 				slog.Info("Instance already stopped", slog.String("instance", s))
@@ -48,10 +48,10 @@ func (c *Compose) StopAll(stateful, force bool, timeout int) error {
 	}
 	return nil
 }
-func (c *Compose) StartAll(wait bool) error {
+func (c *Compose) StartAll(ctx context.Context, wait bool) error {
 	ss := c.ListServices()
 	for _, s := range ss {
-		err := c.StartContainerForService(s, wait)
+		err := c.StartContainerForService(ctx, s, wait)
 		if err != nil {
 			if strings.Contains(err.Error(), "already running") {
 				slog.Info("Instance already running", slog.String("instance", s))
@@ -65,12 +65,12 @@ func (c *Compose) StartAll(wait bool) error {
 
 type remoteResource struct {
 	remote string
-	server incus.InstanceServer
+	server Backend
 	name   string
 }
 
 func (c *Compose) ParseServers(remotes ...string) ([]remoteResource, error) {
-	servers := map[string]incus.InstanceServer{}
+	servers := map[string]Backend{}
 	resources := []remoteResource{}
 
 	for _, remote := range remotes {
@@ -95,7 +95,7 @@ func (c *Compose) ParseServers(remotes ...string) ([]remoteResource, error) {
 		}
 
 		// New connection
-		d, err := c.conf.GetInstanceServer(remoteName)
+		d, err := c.backend(remoteName)
 		if err != nil {
 			return nil, err
 		}
@@ -2,257 +2,166 @@ package application
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
 	"os"
+	"slices"
 	"strings"
 
-	cli "github.com/bketelsen/incus-compose/pkg/incus"
+	"github.com/bketelsen/incus-compose/pkg/types"
+	compose "github.com/compose-spec/compose-go/v2/types"
 	incus "github.com/lxc/incus/v6/client"
 
 	"github.com/lxc/incus/v6/shared/api"
 )
 
-func (app *Compose) RemoveContainerForService(service string, force bool) error {
-	slog.Info("Removing", slog.String("instance", service))
-
-	svc, ok := app.Services[service]
-	if !ok {
-		return fmt.Errorf("service %s not found", service)
-	}
-
-	containerName := svc.GetContainerName()
-
-	d, err := app.getInstanceServer(containerName)
-	if err != nil {
-		return err
-	}
-	d = d.UseProject(app.GetProject())
-
-	inst, _, _ := d.GetInstance(containerName)
-	if inst != nil && inst.Name == containerName {
-		err = app.removeInstance(containerName, force)
-		if err != nil {
-			return err
-		}
-	} else {
-		slog.Info("Instance not found", slog.String("instance", service))
-	}
-
-	return nil
-}
-func (app *Compose) StopContainerForService(service string, stateful, force bool, timeout int) error {
+func (app *Compose) StopContainerForService(ctx context.Context, service string, stateful, force bool, timeout int) error {
 	slog.Info("Stopping", slog.String("instance", service))
 
 	svc, ok := app.Services[service]
 	if !ok {
-		return fmt.Errorf("service %s not found", service)
+		return &types.NotFoundError{Kind: "service", Name: service}
 	}
 	containerName := svc.GetContainerName()
 
-	d, err := app.getInstanceServer(containerName)
+	_, inst, err := app.liveInstance(containerName)
 	if err != nil {
 		return err
 	}
-	d = d.UseProject(app.GetProject())
-
-	inst, _, _ := d.GetInstance(containerName)
-	if inst != nil && inst.Name == containerName && inst.Status == "Running" {
-		err = app.updateInstanceState(containerName, "stop", timeout, force, stateful)
-		if err != nil {
-			return err
-		}
-	} else {
+	if inst == nil {
 		slog.Info("Instance not found", slog.String("instance", containerName))
+		return nil
 	}
 
-	return nil
+	return app.applyActions(ctx, app.stopActions(service, containerName, inst.StatusCode == api.Running, stateful, force, timeout))
 }
-func (app *Compose) StartContainerForService(service string, wait bool) error {
+
+func (app *Compose) StartContainerForService(ctx context.Context, service string, wait bool) error {
 	slog.Info("Starting", slog.String("instance", service))
 
 	svc, ok := app.Services[service]
 	if !ok {
-		return fmt.Errorf("service %s not found", service)
+		return &types.NotFoundError{Kind: "service", Name: service}
 	}
-
 	containerName := svc.GetContainerName()
 
-	d, err := app.getInstanceServer(containerName)
+	d, inst, err := app.liveInstance(containerName)
 	if err != nil {
 		return err
 	}
-
-	d = d.UseProject(app.GetProject())
-
-	inst, _, _ := d.GetInstance(containerName)
-	if inst != nil && inst.Name == containerName && inst.Status == "Running" {
-		slog.Info("Instance already running", slog.String("instance", containerName))
-	} else {
-		err = app.updateInstanceState(containerName, "start", -1, false, false)
-		if err != nil {
-			return err
-		}
+	if inst == nil {
+		return &types.NotFoundError{Kind: "instance", Name: containerName}
 	}
 
-	if wait {
-		if svc.CloudInitUserData != "" || svc.CloudInitUserDataFile != "" {
-			slog.Info("cloud-init", slog.String("instance", containerName), slog.String("status", "waiting"))
-
-			args := []string{"exec", containerName}
-			args = append(args, "--project", app.GetProject())
-			args = append(args, "--", "cloud-init", "status", "--wait")
-			out, code, err := cli.ExecuteShellStreamExitCode(context.Background(), args)
-			if err != nil {
-				slog.Error("Incus error", slog.String("instance", containerName), slog.String("message", out))
-				return err
-			}
-			if code == 2 {
-				slog.Error("cloud-init", slog.String("instance", containerName), slog.String("status", "completed with recoverable errors"))
-			}
+	err = app.applyActions(ctx, app.startActions(service, containerName, inst.StatusCode == api.Running))
+	if err != nil {
+		return err
+	}
 
-			slog.Info("cloud-init", slog.String("instance", containerName), slog.String("status", "done"))
-			slog.Debug("Incus ", slog.String("instance", containerName), slog.String("message", out))
-		}
+	if wait && (svc.CloudInitUserData != "" || svc.CloudInitUserDataFile != "") {
+		return app.waitCloudInit(ctx, d, containerName)
 	}
 	return nil
 }
 
-func (app *Compose) RestartContainerForService(service string) error {
-	slog.Info("Restarting", slog.String("instance", service))
+// waitCloudInit waits for cloud-init to finish in an instance
+func (app *Compose) waitCloudInit(ctx context.Context, d Backend, name string) error {
+	slog.Info("cloud-init", slog.String("instance", name), slog.String("status", "waiting"))
 
-	svc, ok := app.Services[service]
-	if !ok {
-		return fmt.Errorf("service %s not found", service)
+	res, err := execCapture(ctx, d, name, []string{"cloud-init", "status", "--wait"})
+	if err != nil {
+		return fmt.Errorf("failed waiting for cloud-init in %s: %w", name, err)
 	}
+	slog.Debug("cloud-init", slog.String("instance", name), slog.String("output", res.Stdout))
 
-	containerName := svc.GetContainerName()
-
-	return app.updateInstanceState(containerName, "restart", -1, false, false)
-
+	switch res.ExitCode {
+	case 0:
+		slog.Info("cloud-init", slog.String("instance", name), slog.String("status", "done"))
+	case 2:
+		slog.Error("cloud-init", slog.String("instance", name), slog.String("status", "completed with recoverable errors"))
+	default:
+		return fmt.Errorf("cloud-init in %s failed with exit code %d: %s", name, res.ExitCode, strings.TrimSpace(res.Stderr+res.Stdout))
+	}
+	return nil
 }
-func (app *Compose) InitContainerForService(service string) error {
-	slog.Info("Initialize", slog.String("instance", service))
 
-	var image string
-	var iremote string
+func (app *Compose) RestartContainerForService(ctx context.Context, service string) error {
+	slog.Info("Restarting", slog.String("instance", service))
 
-	sc, err := app.ComposeProject.GetService(service)
+	actions, err := app.restartActions(service)
 	if err != nil {
 		return err
 	}
+	return app.applyActions(ctx, actions)
+}
 
-	// Parse the remote
-	remote, name, err := app.conf.ParseRemote(service)
-	if err != nil {
-		return err
+// networkLookup returns the network a NIC of an instance connects to
+type networkLookup func(name string) (*api.Network, error)
+
+// liveNetworks looks networks up on the server
+func liveNetworks(d Backend) networkLookup {
+	return func(name string) (*api.Network, error) {
+		network, _, err := d.GetNetwork(name)
+		return network, err
 	}
+}
 
-	d, err := app.conf.GetInstanceServer(remote)
-	if err != nil {
-		return err
+// instanceForService translates a compose service into the instance that
+// should be created for it on the server d. Custom volumes, bind mounts and
+// secrets are attached separately.
+func (app *Compose) instanceForService(d Backend, sc compose.ServiceConfig, networks networkLookup) (*api.InstancesPost, error) {
+	// overridden storage
+	if pool := app.Services[sc.Name].Storage; pool != "" {
+		_, _, err := d.GetStoragePool(pool)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading storage pool %q: %w", pool, err)
+		}
 	}
 
-	d = d.UseProject(app.GetProject())
+	return app.translateInstance(sc, networks, d.HasExtension("instance_nic_network"))
+}
 
-	inst, _, _ := d.GetInstance(service)
-	if inst != nil && inst.Name == service {
-		slog.Info("Instance found", slog.String("instance", service))
-		return nil
+// translateInstance translates a compose service into the instance that
+// should be created for it, without the image source. nicNetwork tells
+// whether NICs can refer to managed networks by name.
+func (app *Compose) translateInstance(sc compose.ServiceConfig, networks networkLookup, nicNetwork bool) (*api.InstancesPost, error) {
+	svc, ok := app.Services[sc.Name]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: sc.Name}
 	}
 
 	var instancePost api.InstancesPost
 	var devicesMap map[string]map[string]string
 	var configMap map[string]string
 	var profiles []string
-	var userDataFile string
-	var storageOverride string
-	var assignGPU bool
-	var instanceSnapshot *Snapshot
 
 	// add the profiles specified in the compose file
 	profiles = append(profiles, app.GetProfiles()...)
-
-	// parse service extensions
-	for k, v := range sc.Extensions {
-		switch k {
-		case "x-incus-additional-profiles":
-			list, ok := v.([]interface{})
-			if ok {
-				for _, profile := range list {
-					p := profile.(string)
-					profiles = append(profiles, p)
-				}
-			}
-			continue
-		case "x-incus-cloud-init-user-data-file":
-			df, ok := v.(string)
-			if ok {
-				// save it to use later
-				userDataFile = df
-			}
-			continue
-		case "x-incus-storage":
-			pool, ok := v.(string)
-			if ok {
-				// save it to use later
-				storageOverride = pool
-			}
-			continue
-		case "x-incus-gpu":
-			gpu, ok := v.(bool)
-			if ok {
-				// save it to use later
-				assignGPU = gpu
-			}
-			continue
-		case "x-incus-snapshot":
-			snapshot, ok := v.(map[string]interface{})
-			if ok {
-				snap := &Snapshot{}
-				for k, v := range snapshot {
-					switch k {
-					case "schedule":
-						snap.Schedule = v.(string)
-					case "expiry":
-						snap.Expiry = v.(string)
-					case "pattern":
-						snap.Pattern = v.(string)
-					default:
-						slog.Error("Unsupported snapshot configuration", slog.String("key", k), slog.String("value", v.(string)))
-
-					}
-				}
-				instanceSnapshot = snap
-
-			}
-			continue
-		default:
-			slog.Error("Unsupported compose extension", slog.String("key", k), slog.String("value", fmt.Sprintf("%v", v)))
-		}
-	}
+	profiles = append(profiles, svc.AdditionalProfiles...)
 
 	// set up deviceMap
 	devicesMap = map[string]map[string]string{}
 	if len(sc.Networks) > 0 {
 		networkNumber := 0
-		for net := range sc.Networks {
+		// sorted, so NICs keep their names between runs
+		for _, net := range slices.Sorted(maps.Keys(sc.Networks)) {
 			if net == "default" {
 				net = app.DefaultNetworkName()
 			}
 
 			netName := fmt.Sprintf("eth%d", networkNumber)
 
-			network, _, err := d.GetNetwork(net)
+			network, err := networks(net)
 			if err != nil {
-				return fmt.Errorf("failed loading network %q: %w", net, err)
+				return nil, fmt.Errorf("failed loading network %q: %w", net, err)
 			}
 
 			// Prepare the instance's NIC device entry.
 			var device map[string]string
 
-			if network.Managed && d.HasExtension("instance_nic_network") {
+			if network.Managed && nicNetwork {
 				// If network is managed, use the network property rather than nictype, so that the
 				// network's inherited properties are loaded into the NIC when started.
 				device = map[string]string{
@@ -305,15 +214,19 @@ func (app *Compose) InitContainerForService(service string) error {
 	for k, v := range sc.Labels {
 		configMap["user."+k] = v
 	}
-	configMap["user.dev.brian.incus-compose.directory"] = app.ComposeProject.WorkingDir
-	configMap["user.dev.brian.incus-compose"] = "true"
+	labels, err := app.stackLabels(sc.Name, sc)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(configMap, labels)
+	configMap[imageKey] = sc.Image
 
 	// add env vars from file
 	if len(sc.EnvFiles) > 0 {
 		for _, value := range sc.EnvFiles {
 			r, err := readEnvironmentFile(value.Path)
 			if err != nil {
-				return fmt.Errorf("failed reading env file %s: %w", value.Path, err)
+				return nil, fmt.Errorf("failed reading env file %s: %w", value.Path, err)
 			}
 			for k, v := range r {
 				configMap["environment."+k] = v
@@ -321,17 +234,25 @@ func (app *Compose) InitContainerForService(service string) error {
 		}
 	}
 
-	// overridden storage
-	if storageOverride != "" {
-		_, _, err := d.GetStoragePool(storageOverride)
-		if err != nil {
-			return fmt.Errorf("failed loading storage pool %q: %w", storageOverride, err)
+	// remember which environment variables come from the compose file, the
+	// image may set others
+	envNames := []string{}
+	for k := range configMap {
+		if name, ok := strings.CutPrefix(k, "environment."); ok {
+			envNames = append(envNames, name)
 		}
+	}
+	if len(envNames) > 0 {
+		slices.Sort(envNames)
+		configMap[environmentKey] = strings.Join(envNames, ",")
+	}
 
+	// overridden storage
+	if svc.Storage != "" {
 		devicesMap["root"] = map[string]string{
 			"type": "disk",
 			"path": "/",
-			"pool": storageOverride,
+			"pool": svc.Storage,
 		}
 	}
 
@@ -348,70 +269,107 @@ func (app *Compose) InitContainerForService(service string) error {
 	instancePost.Profiles = profiles
 
 	// gpu
-	if assignGPU {
+	if svc.GPU {
 		devicesMap[sc.Name+"GPU"] = map[string]string{
 			"type": "gpu",
 		}
 	}
-	if instanceSnapshot != nil {
-		configMap["snapshots.schedule"] = instanceSnapshot.Schedule
-		configMap["snapshots.pattern"] = instanceSnapshot.Pattern
-		configMap["snapshots.expiry"] = instanceSnapshot.Expiry
+	if svc.Snapshot != nil {
+		configMap["snapshots.schedule"] = svc.Snapshot.Schedule
+		configMap["snapshots.pattern"] = svc.Snapshot.Pattern
+		configMap["snapshots.expiry"] = svc.Snapshot.Expiry
 
 	}
 
-	if userDataFile != "" {
-		bb, err := os.ReadFile(userDataFile)
+	if svc.CloudInitUserDataFile != "" {
+		bb, err := os.ReadFile(svc.CloudInitUserDataFile)
 		if err != nil {
 			slog.Error("Loading cloud-init", slog.String("error", err.Error()))
-			return err
+			return nil, err
 		}
 		configMap["user.user-data"] = string(bb)
 	}
 
+	// remember which devices come from the compose file, including the ones
+	// attached once the instance exists, others are added by hand
+	deviceNames := slices.Collect(maps.Keys(devicesMap))
+	attached, err := app.attachedDevices(sc.Name)
+	if err != nil {
+		return nil, err
+	}
+	deviceNames = append(deviceNames, slices.Collect(maps.Keys(attached))...)
+	secretFiles, err := app.secretFilesForService(sc.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(secretFiles) > 0 {
+		deviceNames = append(deviceNames, secretsDeviceName(sc.Name))
+	}
+	if len(deviceNames) > 0 {
+		slices.Sort(deviceNames)
+		configMap[devicesKey] = strings.Join(deviceNames, ",")
+	}
+
 	instancePost.Devices = devicesMap
 
-	iremote, image, err = app.conf.ParseRemote(sc.Image)
+	return &instancePost, nil
+}
+
+// createInstance resolves the image of a service and creates the instance from it.
+func (app *Compose) createInstance(ctx context.Context, d Backend, remote string, imageRef string, instancePost *api.InstancesPost) error {
+	imgRemote, imgInfo, err := app.instanceImage(d, remote, imageRef, instancePost)
 	if err != nil {
 		return err
 	}
+	return app.submitInstance(ctx, d, imgRemote, imgInfo, instancePost)
+}
 
-	iremote, image = guessImage(app.conf, d, remote, iremote, image)
-	// Deal with the default image
-	if image == "" {
-		image = "default"
-	}
-	imgRemote, imgInfo, err := getImgInfo(d, app.conf, iremote, remote, image, &instancePost.Source)
+// instanceImage resolves the image an instance is created from, filling in
+// the source and type of the instance.
+func (app *Compose) instanceImage(d Backend, remote string, imageRef string, instancePost *api.InstancesPost) (ImageSource, *api.Image, error) {
+	imgRemote, imgInfo, err := app.resolveImage(d, remote, imageRef, &instancePost.Source)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	if app.conf.Remotes[iremote].Protocol == "incus" {
-
+	// images from public image servers aren't looked up, so their type is unknown
+	if imgInfo.Type != "" {
 		instancePost.Type = api.InstanceType(imgInfo.Type)
 	}
+	return imgRemote, imgInfo, nil
+}
 
-	op, err := d.CreateInstanceFromImage(imgRemote, *imgInfo, instancePost)
-	if err != nil {
+// submitInstance creates an instance from an image resolved by instanceImage.
+func (app *Compose) submitInstance(ctx context.Context, d Backend, imgRemote ImageSource, imgInfo *api.Image, instancePost *api.InstancesPost) error {
+	// a retry finds the instance created by an accepted request
+	var op incus.RemoteOperation
+	wait, err := app.submit(ctx, "create instance "+instancePost.Name, func() error {
+		var err error
+		op, err = d.CreateInstanceFromImage(imgRemote, *imgInfo, *instancePost)
 		return err
-	}
-	err = op.Wait()
-	if err != nil {
+	}, func(err error) bool {
+		if !api.StatusErrorCheck(err, http.StatusConflict) {
+			return false
+		}
+		inst, _, getErr := d.GetInstance(instancePost.Name)
+		return getErr == nil && inst.Config[configHashKey] == instancePost.Config[configHashKey]
+	})
+	if !wait {
 		return err
 	}
-	slog.Info("Created instance", slog.String("name", name))
-
-	return nil
-
+	markSubmitted(ctx)
+	return app.waitRemote(ctx, op)
 }
 
-func (app *Compose) updateInstanceState(name string, state string, timeout int, force bool, stateful bool) error {
+// updateInstanceState changes the state of an instance. Stopping and
+// restarting are bounded by the shutdown timeout rather than OperationTimeout.
+func (app *Compose) updateInstanceState(ctx context.Context, name string, state string, timeout int, force bool, stateful bool) error {
 	remote, name, err := app.conf.ParseRemote(name)
 	if err != nil {
 		return err
 	}
 
-	d, err := app.conf.GetInstanceServer(remote)
+	d, err := app.backend(remote)
 	if err != nil {
 		return err
 	}
@@ -425,24 +383,42 @@ func (app *Compose) updateInstanceState(name string, state string, timeout int,
 		Stateful: stateful,
 	}
 
-	op, err := d.UpdateInstanceState(name, req, "")
-	if err != nil {
+	// a retry of an accepted start or stop finds the instance in the state
+	// it asked for
+	reached := map[string]api.StatusCode{"start": api.Running, "stop": api.Stopped}
+	var op incus.Operation
+	wait, err := app.submit(ctx, state+" instance "+name, func() error {
+		var err error
+		op, err = d.UpdateInstanceState(name, req, "")
+		return err
+	}, func(error) bool {
+		want, ok := reached[state]
+		if !ok {
+			return false
+		}
+		inst, _, err := d.GetInstance(name)
+		return err == nil && inst.StatusCode == want
+	})
+	if !wait {
 		return err
 	}
 
-	return op.Wait()
+	if state == "start" {
+		return app.wait(ctx, op)
+	}
+	return waitOperation(ctx, op)
 }
 
-func (app *Compose) getInstanceServer(name string) (incus.InstanceServer, error) {
+func (app *Compose) getInstanceServer(name string) (Backend, error) {
 	remote, _, err := app.conf.ParseRemote(name)
 	if err != nil {
 		return nil, err
 	}
 
-	return app.conf.GetInstanceServer(remote)
+	return app.backend(remote)
 
 }
-func (app *Compose) removeInstance(name string, force bool) error {
+func (app *Compose) removeInstance(ctx context.Context, name string, force bool) error {
 
 	// Parse remote
 	resources, err := app.ParseServers(name)
@@ -458,11 +434,6 @@ func (app *Compose) removeInstance(name string, force bool) error {
 
 	// Process with deletion.
 	for _, resource := range resources {
-		connInfo, err := resource.server.UseProject(app.GetProject()).GetConnectionInfo()
-		if err != nil {
-			return err
-		}
-
 		ct, _, err := resource.server.UseProject(app.GetProject()).GetInstance(resource.name)
 		if err != nil {
 			return err
@@ -470,7 +441,7 @@ func (app *Compose) removeInstance(name string, force bool) error {
 
 		if ct.StatusCode != 0 && ct.StatusCode != api.Stopped {
 			if !force {
-				return fmt.Errorf("the instance is currently running, stop it first or pass --force")
+				return fmt.Errorf("%w: the instance is currently running, stop it first or pass --force", types.ErrConflict)
 			}
 
 			req := api.InstanceStatePut{
@@ -484,7 +455,7 @@ func (app *Compose) removeInstance(name string, force bool) error {
 				return err
 			}
 
-			err = op.Wait()
+			err = waitOperation(ctx, op)
 			if err != nil {
 				return fmt.Errorf("stopping the instance failed: %s", err)
 			}
@@ -516,10 +487,10 @@ func (app *Compose) removeInstance(name string, force bool) error {
 		// Instance delete
 		op, err := resource.server.UseProject(app.GetProject()).DeleteInstance(name)
 		if err != nil {
-			return fmt.Errorf("failed deleting instance %q in project %q: %w", resource.name, connInfo.Project, err)
+			return fmt.Errorf("failed deleting instance %q in project %q: %w", resource.name, app.GetProject(), err)
 		}
 
-		return op.Wait()
+		return app.wait(ctx, op)
 	}
 	return nil
 
@@ -555,7 +526,7 @@ func readEnvironmentFile(path string) (map[string]string, error) {
 	return envMap, nil
 }
 
-func (app *Compose) addDevice(instance, name string, device map[string]string) error {
+func (app *Compose) addDevice(ctx context.Context, instance, name string, device map[string]string) error {
 
 	d, err := app.getInstanceServer(instance)
 	if err != nil {
@@ -563,27 +534,33 @@ func (app *Compose) addDevice(instance, name string, device map[string]string) e
 	}
 	d = d.UseProject(app.GetProject())
 
-	inst, etag, err := d.GetInstance(instance)
-	if err != nil {
-		return err
-	}
-
-	_, ok := inst.Devices[name]
-	if ok {
-		return errors.New("device already exists")
-	}
-
-	inst.Devices[name] = device
-
-	op, err := d.UpdateInstance(instance, inst.Writable(), etag)
-	if err != nil {
-		return err
-	}
+	_, err = app.updateInstance(ctx, d, instance, func(inst *api.Instance) (bool, error) {
+		_, ok := inst.Devices[name]
+		if ok {
+			return false, fmt.Errorf("%w: device %s already exists", types.ErrConflict, name)
+		}
+		inst.Devices[name] = device
+		return true, nil
+	})
+	return err
+}
 
-	err = op.Wait()
+// attachDevice adds a device to an instance unless it already has a device
+// with that name.
+func (app *Compose) attachDevice(ctx context.Context, instance, name string, device map[string]string) error {
+	d, err := app.getInstanceServer(instance)
 	if err != nil {
 		return err
 	}
+	d = d.UseProject(app.GetProject())
 
-	return nil
+	_, err = app.updateInstance(ctx, d, instance, func(inst *api.Instance) (bool, error) {
+		if _, ok := inst.Devices[name]; ok {
+			slog.Info("Device already exists", slog.String("instance", instance), slog.String("name", name))
+			return false, nil
+		}
+		inst.Devices[name] = device
+		return true, nil
+	})
+	return err
 }
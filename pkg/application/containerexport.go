@@ -1,34 +1,23 @@
 package application
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
 	"time"
 
 	incus "github.com/lxc/incus/v6/client"
 	api "github.com/lxc/incus/v6/shared/api"
 )
 
-func (app *Compose) ExportInstance(service string, volumes bool) error {
-	slog.Info("Exporting", slog.String("instance", service))
-
-	fullExportPath := filepath.Join(app.ExportPath, exportName(service))
-	slog.Info("Export File", slog.String("path", fullExportPath))
-
-	return app.instanceExport(service, fullExportPath, !volumes)
-
-}
-
 func exportName(resource string) string {
 	return resource + "-" + "export" + "-" + time.Now().Format("2006-01-02-15-04-05") + ".tar.gz"
 }
 
-func (app *Compose) instanceExport(instanceName, targetName string, instanceOnly bool) error {
+func (app *Compose) instanceExport(ctx context.Context, instanceName, targetName string, instanceOnly bool) error {
 	d, err := app.getInstanceServer(instanceName)
 	if err != nil {
 		return err
@@ -43,13 +32,19 @@ func (app *Compose) instanceExport(instanceName, targetName string, instanceOnly
 		OptimizedStorage: false,
 	}
 
-	op, err := d.CreateInstanceBackup(instanceName, req)
+	// the server names backups, a backup made by a request accepted before
+	// its connection failed is left to expire
+	var op incus.Operation
+	_, err = app.submit(ctx, "create instance backup "+instanceName, func() error {
+		op, err = d.CreateInstanceBackup(instanceName, req)
+		return err
+	}, nil)
 	if err != nil {
 		return fmt.Errorf("create instance backup: %w", err)
 	}
-	err = op.Wait()
+	err = app.wait(ctx, op)
 	if err != nil {
-		return err
+		return fmt.Errorf("create instance backup: %w", err)
 	}
 	// Get name of backup
 	uStr := op.Get().Resources["backups"][0]
@@ -67,7 +62,7 @@ func (app *Compose) instanceExport(instanceName, targetName string, instanceOnly
 		// Delete backup after we're done
 		op, err = d.DeleteInstanceBackup(instanceName, backupName)
 		if err == nil {
-			_ = op.Wait()
+			_ = app.wait(context.WithoutCancel(ctx), op)
 		}
 	}()
 
@@ -80,12 +75,21 @@ func (app *Compose) instanceExport(instanceName, targetName string, instanceOnly
 
 	defer func() { _ = target.Close() }()
 
+	downloadCtx, cancel := app.operationContext(ctx)
+	defer cancel()
+	canceller, done := downloadCanceller(downloadCtx)
+	defer done()
+
 	backupFileRequest := incus.BackupFileRequest{
 		BackupFile: io.WriteSeeker(target),
+		Canceler:   canceller,
 	}
 	_, err = d.GetInstanceBackupFile(instanceName, backupName, &backupFileRequest)
 	if err != nil {
 		_ = os.Remove(targetName)
+		if downloadCtx.Err() != nil {
+			err = context.Cause(downloadCtx)
+		}
 		return fmt.Errorf("fetch instance backup file: %w", err)
 	}
 	err = target.Close()
@@ -1,26 +1,15 @@
 package application
 
 import (
-	"fmt"
-	"log/slog"
+	"context"
+	"net/http"
 	"time"
 
+	incus "github.com/lxc/incus/v6/client"
 	api "github.com/lxc/incus/v6/shared/api"
 )
 
-func (app *Compose) SnapshotInstance(service string, noexpiry, stateful, volumes bool) error {
-	slog.Info("Showing", slog.String("instance", service))
-	svc, ok := app.Services[service]
-	if !ok {
-		return fmt.Errorf("service %s not found", service)
-	}
-
-	containerName := svc.GetContainerName()
-	return app.createSnapshot(containerName, snapshotName(containerName), stateful, noexpiry, time.Now().Add(time.Hour*24*7))
-
-}
-
-func (app *Compose) createSnapshot(instanceName, snapshotName string, stateful bool, noexpiry bool, expiration time.Time) error {
+func (app *Compose) createSnapshot(ctx context.Context, instanceName, snapshotName string, stateful bool, noexpiry bool, expiration time.Time) error {
 	d, err := app.getInstanceServer(instanceName)
 	if err != nil {
 		return err
@@ -39,13 +28,20 @@ func (app *Compose) createSnapshot(instanceName, snapshotName string, stateful b
 		req.ExpiresAt = &expiration
 	}
 
-	op, err := d.CreateInstanceSnapshot(instanceName, req)
-	if err != nil {
+	// snapshot names carry the time they're taken, one that exists when
+	// retrying was taken by the accepted request
+	var op incus.Operation
+	wait, err := app.submit(ctx, "snapshot instance "+instanceName, func() error {
+		op, err = d.CreateInstanceSnapshot(instanceName, req)
+		return err
+	}, func(err error) bool {
+		return api.StatusErrorCheck(err, http.StatusConflict)
+	})
+	if !wait {
 		return err
 	}
 
-	return op.Wait()
-
+	return app.wait(ctx, op)
 }
 
 func snapshotName(resource string) string {
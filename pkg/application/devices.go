@@ -1,20 +1,23 @@
 package application
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
 )
 
-func (app *Compose) CreateGPUForService(service string) error {
+func (app *Compose) CreateGPUForService(ctx context.Context, service string) error {
 
 	svc, ok := app.Services[service]
 	if !ok {
-		return fmt.Errorf("service %s not found", service)
+		return &types.NotFoundError{Kind: "service", Name: service}
 	}
 	if svc.GPU {
 		slog.Info("Adding GPU Device", slog.String("instance", service))
 
-		err := app.createGPU(service)
+		err := app.createGPU(ctx, service)
 		if err != nil {
 			return err
 		}
@@ -23,7 +26,7 @@ func (app *Compose) CreateGPUForService(service string) error {
 	return nil
 }
 
-func (app *Compose) createGPU(service string) error {
+func (app *Compose) createGPU(ctx context.Context, service string) error {
 	slog.Info("Create GPU", slog.String("instance", service))
 
 	bindName := service + "-gpu"
@@ -34,10 +37,12 @@ func (app *Compose) createGPU(service string) error {
 	device["type"] = "gpu"
 	slog.Info("Creating BindMount", slog.String("name", bindName))
 
-	err := app.addDevice(service, bindName, device)
+	start := time.Now()
+	err := app.addDevice(ctx, service, bindName, device)
 	if err != nil {
 		return err
 	}
+	app.applied(start, newAction("attach", "device", service, service+"/"+bindName, device, nil))
 
 	return nil
 }
@@ -0,0 +1,208 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// Drift is a difference between the compose file and the live state of the
+// stack.
+type Drift struct {
+	Service string `json:"service,omitempty" yaml:"service,omitempty"`
+	// Object is the instance, volume or network the difference was found on.
+	Object string `json:"object,omitempty" yaml:"object,omitempty"`
+	// Kind is what differs: instance, volume, network, image, config,
+	// device, profiles or description.
+	Kind string `json:"kind" yaml:"kind"`
+	// Name is the configuration key or device name, if any.
+	Name string `json:"name,omitempty" yaml:"name,omitempty"`
+	// Change is missing, added, changed or removed. Added and removed are
+	// seen from the compose file: an added key is in the compose file but
+	// not on the live object.
+	Change string `json:"change" yaml:"change"`
+	// Recreate is set when the change can only be applied by recreating the
+	// instance.
+	Recreate bool `json:"recreate,omitempty" yaml:"recreate,omitempty"`
+}
+
+func (d Drift) String() string {
+	parts := []string{d.Kind}
+	if d.Name != "" {
+		parts = append(parts, d.Name)
+	}
+	return strings.Join(append(parts, d.Change), " ")
+}
+
+func joinDrift(drifts []Drift) string {
+	s := make([]string, 0, len(drifts))
+	for _, d := range drifts {
+		s = append(s, d.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+// Diff compares the instances, devices, custom volumes and default network
+// of the given services, or of all services when none are given, with the
+// compose file. Nothing is changed.
+func (app *Compose) Diff(ctx context.Context, services []string) ([]Drift, error) {
+	drifts := []Drift{}
+
+	declared, exists, err := app.liveDefaultNetwork()
+	if err != nil {
+		return nil, err
+	}
+	if declared {
+		networkDrift, err := app.diffDefaultNetwork(exists)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, networkDrift...)
+	}
+
+	for _, service := range app.levelOrder(services, false) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		serviceDrift, err := app.diffService(service)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, serviceDrift...)
+	}
+
+	return drifts, nil
+}
+
+// diffDefaultNetwork compares the default network with the one
+// CreateDefaultNetwork would create.
+func (app *Compose) diffDefaultNetwork(exists bool) ([]Drift, error) {
+	name := app.DefaultNetworkName()
+	if !exists {
+		return []Drift{{Object: name, Kind: "network", Change: "missing"}}, nil
+	}
+
+	resources, err := app.ParseServers(name)
+	if err != nil {
+		return nil, err
+	}
+	resource := resources[0]
+
+	network, _, err := resource.server.GetNetwork(resource.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed loading network %q: %w", resource.name, err)
+	}
+
+	desired := defaultNetworkPost(resource.name, "")
+	if network.Type != desired.Type {
+		return []Drift{{Object: name, Kind: "network", Name: "type", Change: "changed"}}, nil
+	}
+	return nil, nil
+}
+
+// diffService compares the instance and the custom volumes of a service
+// with the compose file.
+func (app *Compose) diffService(service string) ([]Drift, error) {
+	sc, err := app.ComposeProject.GetService(service)
+	if err != nil {
+		return nil, err
+	}
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+
+	d, inst, err := app.liveInstance(containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	drifts := []Drift{}
+
+	// missing networks are reported and treated as managed bridges, so the
+	// rest of the instance can still be compared
+	defaultNetwork := app.DefaultNetworkName()
+	missingNetworks := map[string]bool{}
+	live := liveNetworks(d)
+	networks := func(name string) (*api.Network, error) {
+		network, err := live(name)
+		if err != nil && api.StatusErrorCheck(err, http.StatusNotFound) {
+			missingNetworks[name] = true
+			planned := defaultNetworkPost(name, "")
+			return &api.Network{Name: name, Type: planned.Type, Managed: true}, nil
+		}
+		return network, err
+	}
+
+	if inst == nil {
+		drifts = append(drifts, Drift{Service: service, Object: containerName, Kind: "instance", Change: "missing"})
+	} else {
+		desired, err := app.desiredInstance(d, sc, networks)
+		if err != nil {
+			return nil, err
+		}
+		drift := diffInstance(desired.InstancePut, inst)
+		for _, change := range drift.changes {
+			change.Service, change.Object = service, containerName
+			drifts = append(drifts, change)
+		}
+		for _, change := range drift.recreate {
+			change.Service, change.Object, change.Recreate = service, containerName, true
+			drifts = append(drifts, change)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(missingNetworks)) {
+		if name == defaultNetwork {
+			// reported once for the stack
+			continue
+		}
+		drifts = append(drifts, Drift{Service: service, Object: name, Kind: "network", Change: "missing"})
+	}
+
+	for _, volName := range slices.Sorted(maps.Keys(svc.Volumes)) {
+		vol := svc.Volumes[volName]
+		name := vol.CreateName(app.Name, containerName, volName)
+		volumeDrift, err := app.diffVolume(d, service, name, *vol)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range volumeDrift {
+			change.Service = service
+			drifts = append(drifts, change)
+		}
+	}
+
+	return drifts, nil
+}
+
+// diffVolume compares a custom volume with the one createVolume would
+// create. Snapshot settings and labels no longer in the compose file are
+// reported as removed, other keys are left alone.
+func (app *Compose) diffVolume(d Backend, service string, name string, vol Volume) ([]Drift, error) {
+	desired, err := app.volumePost(service, name, vol)
+	if err != nil {
+		return nil, err
+	}
+
+	live, _, err := d.GetStoragePoolVolume(vol.Pool, desired.Type, desired.Name)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return []Drift{{Object: name, Kind: "volume", Change: "missing"}}, nil
+		}
+		return nil, fmt.Errorf("failed loading volume %q: %w", name, err)
+	}
+
+	drifts, _ := diffConfig(desired.Config, live.Config, managedVolumeKey)
+	for i := range drifts {
+		drifts[i].Object = name
+	}
+	return drifts, nil
+}
@@ -0,0 +1,66 @@
+package application
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Event is an action applied to a stack, or a command that finished,
+// written by EventWriter as one line of JSON
+type Event struct {
+	Time    time.Time `json:"time"`
+	Stack   string    `json:"stack"`
+	Action  string    `json:"action"`
+	Kind    string    `json:"kind"`
+	Service string    `json:"service,omitempty"`
+	// Resource is the name of the instance, volume, network, device,
+	// snapshot or export, or the stack for commands
+	Resource   string            `json:"resource"`
+	Details    map[string]string `json:"details,omitempty"`
+	DurationMS int64             `json:"duration_ms"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// EventWriter is a hook writing every applied action as an event
+type EventWriter struct {
+	mu    sync.Mutex
+	enc   *json.Encoder
+	stack string
+}
+
+// NewEventWriter returns an EventWriter writing the events of stack to w
+func NewEventWriter(w io.Writer, stack string) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w), stack: stack}
+}
+
+func (e *EventWriter) Applied(action Action) {
+	e.Write(Event{
+		Action:     action.Verb,
+		Kind:       action.Kind,
+		Service:    action.Service,
+		Resource:   action.Name,
+		Details:    action.Details,
+		DurationMS: action.Duration.Milliseconds(),
+	})
+}
+
+// Write writes an event, filling in its time and stack when they're not
+// set. Events that can't be written are logged and dropped.
+func (e *EventWriter) Write(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if event.Stack == "" {
+		event.Stack = e.stack
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	err := e.enc.Encode(event)
+	if err != nil {
+		slog.Warn("Event not written", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,138 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/gorilla/websocket"
+	incus "github.com/lxc/incus/v6/client"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// ExecOptions controls how a command is run inside a service instance.
+type ExecOptions struct {
+	// Interactive allocates a single PTY instead of three pipes.
+	Interactive bool
+	// Environment is passed to the command in addition to the instance environment.
+	Environment map[string]string
+	// User and Group are the numeric ids the command runs as.
+	User  uint32
+	Group uint32
+	// Cwd is the working directory of the command.
+	Cwd string
+	// Width and Height are the initial terminal size for interactive commands.
+	Width  int
+	Height int
+
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Control handles the control websocket (window resize, signals). done is
+	// closed once the command has finished.
+	Control func(conn *websocket.Conn, done <-chan struct{})
+}
+
+// ExecContainerForService runs a command in the running instance of a service
+// and returns the exit code of the command. Waiting for the command stops when
+// ctx is done, the command itself is not interrupted.
+func (app *Compose) ExecContainerForService(ctx context.Context, service string, command []string, opts ExecOptions) (int, error) {
+	slog.Debug("Exec", slog.String("instance", service), slog.Any("command", command))
+
+	svc, ok := app.Services[service]
+	if !ok {
+		return -1, &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+
+	d, err := app.getInstanceServer(containerName)
+	if err != nil {
+		return -1, err
+	}
+	d = d.UseProject(app.GetProject())
+
+	inst, _, err := d.GetInstance(containerName)
+	if err != nil {
+		return -1, fmt.Errorf("failed loading instance %q: %w", containerName, err)
+	}
+	if inst.StatusCode != api.Running {
+		return -1, fmt.Errorf("instance %s is not running", containerName)
+	}
+
+	return execInstance(ctx, d, containerName, command, opts)
+}
+
+// ExecResult is the captured output and exit code of a command
+type ExecResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// execCapture runs a command in an instance through the Incus API, without
+// stdin, and captures its output and exit code. A command exiting with a
+// non-zero code isn't an error.
+func execCapture(ctx context.Context, d Backend, name string, command []string) (ExecResult, error) {
+	var stdout, stderr bytes.Buffer
+	code, err := execInstance(ctx, d, name, command, ExecOptions{
+		Stdin:  bytes.NewReader(nil),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	return ExecResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: code}, err
+}
+
+// execInstance runs a command in an instance and waits for it and all of its
+// output to complete.
+func execInstance(ctx context.Context, d Backend, name string, command []string, opts ExecOptions) (int, error) {
+	req := api.InstanceExecPost{
+		Command:     command,
+		WaitForWS:   true,
+		Interactive: opts.Interactive,
+		Environment: opts.Environment,
+		Width:       opts.Width,
+		Height:      opts.Height,
+		User:        opts.User,
+		Group:       opts.Group,
+		Cwd:         opts.Cwd,
+	}
+
+	execArgs := incus.InstanceExecArgs{
+		Stdin:    opts.Stdin,
+		Stdout:   opts.Stdout,
+		Stderr:   opts.Stderr,
+		DataDone: make(chan bool),
+	}
+	done := make(chan struct{})
+	defer close(done)
+	if opts.Control != nil {
+		execArgs.Control = func(conn *websocket.Conn) { opts.Control(conn, done) }
+	}
+
+	op, err := d.ExecInstance(name, req, &execArgs)
+	if err != nil {
+		return -1, err
+	}
+
+	err = waitOperation(ctx, op)
+	code := -1
+	opAPI := op.Get()
+	if opAPI.Metadata != nil {
+		ret, ok := opAPI.Metadata["return"].(float64)
+		if ok {
+			code = int(ret)
+		}
+	}
+	if err != nil {
+		return code, err
+	}
+
+	// wait for any remaining output to be flushed
+	<-execArgs.DataDone
+
+	return code, nil
+}
@@ -0,0 +1,199 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/dominikbraun/graph"
+)
+
+// ServiceResult is the outcome of an operation on one service.
+type ServiceResult struct {
+	Service string
+	Err     error
+	// Skipped is set for services that weren't started because an
+	// operation on another service failed.
+	Skipped bool
+	// Cancelled is set for services whose operation was interrupted because
+	// an operation on another service failed.
+	Cancelled bool
+}
+
+// ExecutionError is returned when an operation failed for at least one
+// service. It holds the outcome for every service.
+type ExecutionError struct {
+	Results []ServiceResult
+}
+
+func (e *ExecutionError) Error() string {
+	failed := []string{}
+	for _, r := range e.Results {
+		if r.Err != nil && !r.Cancelled {
+			failed = append(failed, r.Service+": "+r.Err.Error())
+		}
+	}
+	return fmt.Sprintf("%d of %d services failed: %s", len(failed), len(e.Results), strings.Join(failed, "; "))
+}
+
+func (e *ExecutionError) Unwrap() []error {
+	errs := []error{}
+	for _, r := range e.Results {
+		if r.Err != nil && !r.Cancelled {
+			errs = append(errs, r.Err)
+		}
+	}
+	return errs
+}
+
+// Levels groups services by their depth in the dependency graph. Services in
+// a level only depend on services in earlier levels, so the services of one
+// level can run concurrently. When reverse is true the levels are reversed,
+// for stopping services before the services they depend on.
+func (app *Compose) Levels(services []string, reverse bool) [][]string {
+	services = app.OrderFor(services, !reverse)
+
+	// edges point from a service to its dependencies
+	dependencies := map[string]map[string]graph.Edge[string]{}
+	if app.Dag != nil {
+		adjacency, err := app.Dag.AdjacencyMap()
+		if err == nil {
+			dependencies = adjacency
+		}
+	}
+
+	depth := map[string]int{}
+	var depthOf func(s string) int
+	depthOf = func(s string) int {
+		if d, ok := depth[s]; ok {
+			return d
+		}
+		d := 0
+		for dep := range dependencies[s] {
+			d = max(d, depthOf(dep)+1)
+		}
+		depth[s] = d
+		return d
+	}
+
+	levels := [][]string{}
+	for _, s := range services {
+		d := depthOf(s)
+		for len(levels) <= d {
+			levels = append(levels, []string{})
+		}
+		levels[d] = append(levels[d], s)
+	}
+	levels = slices.DeleteFunc(levels, func(l []string) bool { return len(l) == 0 })
+
+	if reverse {
+		slices.Reverse(levels)
+	}
+	return levels
+}
+
+// runLevels runs fn for the given services, or all services when none are
+// given, level by level (see Levels). Services within a level run
+// concurrently, limited by Parallel. Once an operation fails or ctx is done no
+// further operations are started, the running ones are cancelled and waited
+// for, and an ExecutionError with the outcome of every service is returned.
+func (app *Compose) runLevels(ctx context.Context, services []string, reverse bool, fn func(ctx context.Context, service string) error) error {
+	levels := app.Levels(services, reverse)
+
+	parent := ctx
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+
+	limit := app.Parallel
+	if limit <= 0 {
+		limit = len(app.Services)
+	}
+	sem := make(chan struct{}, max(limit, 1))
+
+	var mu sync.Mutex
+	results := map[string]*ServiceResult{}
+	failed := false
+
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return failed || ctx.Err() != nil
+	}
+
+	for _, level := range levels {
+		var wg sync.WaitGroup
+		for _, service := range level {
+			results[service] = &ServiceResult{Service: service, Skipped: true}
+		}
+		for _, service := range level {
+			acquired := false
+			select {
+			case sem <- struct{}{}:
+				acquired = true
+			case <-ctx.Done():
+			}
+			if stopped() {
+				if acquired {
+					<-sem
+				}
+				break
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				err := fn(ctx, service)
+
+				mu.Lock()
+				defer mu.Unlock()
+				results[service].Skipped = false
+				results[service].Err = err
+				switch {
+				case err == nil:
+				case failed && (errors.Is(err, context.Canceled) || errors.Is(err, context.Cause(ctx))):
+					results[service].Cancelled = true
+				default:
+					failed = true
+					cancel(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if stopped() {
+			break
+		}
+	}
+
+	if !failed {
+		return context.Cause(parent)
+	}
+
+	execErr := &ExecutionError{}
+	for _, level := range levels {
+		for _, service := range level {
+			r, ok := results[service]
+			if !ok {
+				r = &ServiceResult{Service: service, Skipped: true}
+			}
+			switch {
+			case r.Skipped:
+				slog.Warn("Skipped", slog.String("instance", service))
+			case r.Cancelled:
+				slog.Warn("Cancelled", slog.String("instance", service))
+			case r.Err != nil:
+				slog.Error("Failed", slog.String("instance", service), slog.String("error", r.Err.Error()))
+			default:
+				slog.Info("Done", slog.String("instance", service))
+			}
+			execErr.Results = append(execErr.Results, *r)
+		}
+	}
+	return execErr
+}
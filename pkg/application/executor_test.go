@@ -0,0 +1,96 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+)
+
+func TestRunLevelsCancelsOnFailure(t *testing.T) {
+	app, _ := newTestApp(t, `
+name: shop
+services:
+  cache:
+    image: alpine
+  db:
+    image: alpine
+  web:
+    image: alpine
+    depends_on:
+      - db
+`)
+
+	broken := errors.New("image not found")
+	started := make(chan struct{})
+	err := app.runLevels(context.Background(), nil, false, func(ctx context.Context, service string) error {
+		switch service {
+		case "db":
+			// fail once cache is running
+			<-started
+			return broken
+		case "cache":
+			close(started)
+			select {
+			case <-ctx.Done():
+				return context.Cause(ctx)
+			case <-time.After(5 * time.Second):
+				return errors.New("not cancelled")
+			}
+		}
+		return nil
+	})
+
+	var execErr *ExecutionError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("error %v isn't an execution error", err)
+	}
+	if !errors.Is(err, broken) {
+		t.Errorf("error %v doesn't wrap the failure", err)
+	}
+	if err.Error() != "1 of 3 services failed: db: image not found" {
+		t.Errorf("error: %v", err)
+	}
+
+	results := map[string]ServiceResult{}
+	for _, r := range execErr.Results {
+		results[r.Service] = r
+	}
+	if r := results["db"]; r.Err != broken || r.Cancelled || r.Skipped {
+		t.Errorf("db: %+v, want failed", r)
+	}
+	if r := results["cache"]; !r.Cancelled || !errors.Is(r.Err, broken) {
+		t.Errorf("cache: %+v, want cancelled", r)
+	}
+	if r := results["web"]; !r.Skipped {
+		t.Errorf("web: %+v, want skipped", r)
+	}
+}
+
+func TestStartReportsFailure(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+	err := app.Create(context.Background(), nil, RecreateNever)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	fake.failNext("UpdateInstanceState", &types.IncusError{Remote: "local", Err: errors.New("connection reset")})
+	err = app.Start(context.Background(), nil, false)
+
+	var execErr *ExecutionError
+	if !errors.As(err, &execErr) || types.ExitCode(err) != types.ExitIncusAPI {
+		t.Fatalf("error %v isn't an execution error of Incus", err)
+	}
+	if got := fake.called("UpdateInstanceState"); len(got) != 0 {
+		t.Errorf("instances started: %v", got)
+	}
+	want := []ServiceResult{
+		{Service: "db", Err: execErr.Results[0].Err},
+		{Service: "web", Skipped: true},
+	}
+	if len(execErr.Results) != 2 || execErr.Results[0] != want[0] || execErr.Results[0].Err == nil || execErr.Results[1] != want[1] {
+		t.Errorf("results: %+v", execErr.Results)
+	}
+}
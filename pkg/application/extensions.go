@@ -0,0 +1,176 @@
+package application
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// extensionPrefix starts the compose extensions incus-compose reads, other
+// x- extensions belong to other tools or hold YAML anchors
+const extensionPrefix = "x-incus-"
+
+// ProjectExtensions are the x-incus extensions at the top of a compose file.
+// The description tags document them in the JSON Schema.
+type ProjectExtensions struct {
+	DefaultProfiles []string `mapstructure:"x-incus-default-profiles" description:"Profiles applied to every instance, instead of the default profile."`
+	Project         string   `mapstructure:"x-incus-project" description:"Incus project the stack is deployed to."`
+}
+
+// ServiceExtensions are the x-incus extensions of a service
+type ServiceExtensions struct {
+	AdditionalProfiles    []string  `mapstructure:"x-incus-additional-profiles" description:"Profiles applied to the instance after the default profiles."`
+	CloudInitUserDataFile string    `mapstructure:"x-incus-cloud-init-user-data-file" description:"File holding the cloud-init user data of the instance."`
+	Storage               string    `mapstructure:"x-incus-storage" description:"Storage pool of the root disk and of the volumes of the instance."`
+	GPU                   bool      `mapstructure:"x-incus-gpu" description:"Pass a GPU of the host through to the instance."`
+	Snapshot              *Snapshot `mapstructure:"x-incus-snapshot" description:"Scheduled snapshots of the instance."`
+}
+
+// VolumeExtensions are the x-incus extensions of a top level volume
+type VolumeExtensions struct {
+	Snapshot *Snapshot `mapstructure:"x-incus-snapshot" description:"Scheduled snapshots of the volume."`
+}
+
+// MountExtensions are the x-incus extensions of a volume or bind mount of
+// a service
+type MountExtensions struct {
+	Shift bool `mapstructure:"x-incus-shift" description:"Shift the ownership of the files to the user namespace of the instance."`
+}
+
+// extensionDecoder decodes the x-incus extensions of a compose project and
+// collects the errors, located in the compose files
+type extensionDecoder struct {
+	*composeFiles
+	errs []error
+}
+
+func newExtensionDecoder(files []string) *extensionDecoder {
+	return &extensionDecoder{composeFiles: readComposeFiles(files)}
+}
+
+// decode decodes the x-incus extensions of the element at path into ext, a
+// pointer to one of the extension structs. Unknown and mistyped extensions
+// are recorded as errors.
+func (d *extensionDecoder) decode(extensions map[string]any, ext any, path ...string) {
+	fields := extensionFields(ext)
+	for _, k := range slices.Sorted(maps.Keys(extensions)) {
+		if !strings.HasPrefix(k, extensionPrefix) {
+			continue
+		}
+		at := append(slices.Clone(path), k)
+
+		field, ok := fields[k]
+		if !ok {
+			d.errs = append(d.errs, d.errorAt(at, errors.New("unsupported extension")))
+			continue
+		}
+
+		dec, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			ErrorUnused: true,
+			Result:      ext,
+		})
+		if err != nil {
+			d.errs = append(d.errs, d.errorAt(at, err))
+			continue
+		}
+		v := extensions[k]
+		err = dec.Decode(map[string]any{k: v})
+		if err != nil && !d.decodeMapping(at, field, v) {
+			d.errs = append(d.errs, d.errorAt(at, fmt.Errorf("must be %s, got %s", expected(field), describe(v))))
+		}
+	}
+}
+
+// decodeMapping records the errors of the keys of a mapping given to a
+// struct field, like expire in x-incus-snapshot. It reports whether it found
+// any, the value isn't a mapping otherwise.
+func (d *extensionDecoder) decodeMapping(path []string, field reflect.StructField, v any) bool {
+	mapping, ok := v.(map[string]any)
+	t := field.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if !ok || t.Kind() != reflect.Struct {
+		return false
+	}
+
+	fields := extensionFields(reflect.New(t).Interface())
+	found := false
+	for _, k := range slices.Sorted(maps.Keys(mapping)) {
+		at := append(slices.Clone(path), k)
+		field, ok := fields[k]
+		if !ok {
+			d.errs = append(d.errs, d.errorAt(at, errors.New("unsupported key")))
+			found = true
+			continue
+		}
+		err := mapstructure.Decode(map[string]any{k: mapping[k]}, reflect.New(t).Interface())
+		if err != nil {
+			d.errs = append(d.errs, d.errorAt(at, fmt.Errorf("must be %s, got %s", expected(field), describe(mapping[k]))))
+			found = true
+		}
+	}
+	return found
+}
+
+// err returns the errors recorded so far
+func (d *extensionDecoder) err() error {
+	return errors.Join(d.errs...)
+}
+
+// extensionFields returns the fields of an extension struct by extension
+// name
+func extensionFields(ext any) map[string]reflect.StructField {
+	t := reflect.TypeOf(ext).Elem()
+	fields := map[string]reflect.StructField{}
+	for i := range t.NumField() {
+		fields[t.Field(i).Tag.Get("mapstructure")] = t.Field(i)
+	}
+	return fields
+}
+
+// expected describes the values a field accepts
+func expected(field reflect.StructField) string {
+	t := field.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "true or false"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice:
+		return "a list of strings"
+	case reflect.Struct:
+		keys := []string{}
+		for i := range t.NumField() {
+			keys = append(keys, t.Field(i).Tag.Get("mapstructure"))
+		}
+		return "a mapping of " + strings.Join(keys, ", ") + " to strings"
+	default:
+		return t.String()
+	}
+}
+
+// describe describes a value found in a compose file
+func describe(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "nothing"
+	case string:
+		return strconv.Quote(v)
+	case []any:
+		return "a list"
+	case map[string]any:
+		return "a mapping"
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,157 @@
+package application
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	cliconfig "github.com/lxc/incus/v6/shared/cliconfig"
+)
+
+func TestExtensions(t *testing.T) {
+	project := loadTestProject(t, `
+name: shop
+x-incus-project: shop
+x-incus-default-profiles: [default, web]
+x-other-tool: ignored
+services:
+  db:
+    image: alpine
+    x-incus-additional-profiles: [gpu]
+    x-incus-storage: fast
+    x-incus-gpu: true
+    x-incus-cloud-init-user-data-file: user-data.yaml
+    x-incus-snapshot:
+      schedule: "@daily"
+      expiry: 2w
+    volumes:
+      - data:/var/lib/db
+      - type: bind
+        source: /srv/db
+        target: /srv
+        x-incus-shift: true
+volumes:
+  data:
+    x-incus-snapshot:
+      pattern: data-{{creation_date}}
+`)
+	app, err := BuildDirect(project, cliconfig.NewConfig("", true))
+	if err != nil {
+		t.Fatalf("build: %v", err)
+	}
+
+	if app.Project != "shop" || !slices.Equal(app.Profiles, []string{"default", "web"}) {
+		t.Errorf("project extensions: %s, %v", app.Project, app.Profiles)
+	}
+	db := app.Services["db"]
+	if !slices.Equal(db.AdditionalProfiles, []string{"gpu"}) || db.Storage != "fast" || !db.GPU || db.CloudInitUserDataFile != "user-data.yaml" {
+		t.Errorf("service extensions: %+v", db)
+	}
+	if db.Snapshot == nil || *db.Snapshot != (Snapshot{Schedule: "@daily", Expiry: "2w"}) {
+		t.Errorf("service snapshot: %+v", db.Snapshot)
+	}
+	if len(db.BindMounts) != 1 || !db.BindMounts["srv-db"].Shift {
+		t.Errorf("bind mounts: %+v", db.BindMounts)
+	}
+	data := db.Volumes["data"]
+	if data.Snapshot == nil || data.Snapshot.Pattern != "data-{{creation_date}}" || data.Pool != "fast" {
+		t.Errorf("volume: %+v", data)
+	}
+}
+
+func TestExtensionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "mistyped",
+			yaml: `
+name: shop
+services:
+  web:
+    image: alpine
+    x-incus-gpu: "yes"
+`,
+			want: []string{`compose.yaml:6:5: services.web.x-incus-gpu: must be true or false, got "yes"`},
+		},
+		{
+			name: "unknown",
+			yaml: `
+name: shop
+x-incus-projects: shop
+services:
+  web:
+    image: alpine
+`,
+			want: []string{`compose.yaml:3:1: x-incus-projects: unsupported extension`},
+		},
+		{
+			name: "snapshot",
+			yaml: `
+name: shop
+services:
+  web:
+    image: alpine
+    x-incus-snapshot:
+      schedule: 1
+      expire: 2w
+`,
+			want: []string{
+				`compose.yaml:8:7: services.web.x-incus-snapshot.expire: unsupported key`,
+				`compose.yaml:7:7: services.web.x-incus-snapshot.schedule: must be a string, got 1`,
+			},
+		},
+		{
+			name: "snapshot not a mapping",
+			yaml: `
+name: shop
+services:
+  web:
+    image: alpine
+    x-incus-snapshot: "@daily"
+`,
+			want: []string{`compose.yaml:6:5: services.web.x-incus-snapshot: must be a mapping of schedule, expiry, pattern to strings, got "@daily"`},
+		},
+		{
+			name: "all errors",
+			yaml: `
+name: shop
+x-incus-default-profiles: default
+services:
+  web:
+    image: alpine
+    volumes:
+      - type: bind
+        source: /srv
+        target: /srv
+        x-incus-shift: 1
+`,
+			want: []string{
+				`compose.yaml:3:1: x-incus-default-profiles: must be a list of strings, got "default"`,
+				`compose.yaml:11:9: services.web.volumes.0.x-incus-shift: must be true or false, got 1`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project := loadTestProject(t, tt.yaml)
+			_, err := BuildDirect(project, cliconfig.NewConfig("", true))
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if !errors.Is(err, types.ErrComposeFile) || types.ExitCode(err) != types.ExitComposeFile {
+				t.Errorf("error %v isn't a compose file error", err)
+			}
+
+			got := strings.ReplaceAll(err.Error(), filepath.Dir(project.ComposeFiles[0])+string(filepath.Separator), "")
+			if got != strings.Join(tt.want, "\n") {
+				t.Errorf("error:\n%s\nwant:\n%s", got, strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
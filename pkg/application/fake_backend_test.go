@@ -0,0 +1,671 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	incus "github.com/lxc/incus/v6/client"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// fakeIncus is an in-memory Incus server. It keeps the instances, volumes,
+// networks, snapshots and backups created through it and records every
+// call that changes them.
+type fakeIncus struct {
+	mu sync.Mutex
+
+	projects  map[string]*fakeVersioned[api.Project]
+	profiles  []string
+	pools     []string
+	instances map[string]*fakeVersioned[api.Instance]
+	volumes   map[string]*api.StorageVolume
+	networks  map[string]*api.Network
+	snapshots []string
+	backups   map[string]bool
+
+	// calls are the changes made, like "CreateNetwork web"
+	calls []string
+	// failures are the errors the next calls of a method return
+	failures map[string][]error
+	// exec answers the commands run in instances, they succeed without
+	// output when it is nil
+	exec func(instance string, command []string) (stdout string, code int)
+}
+
+// fakeVersioned is a resource with the etag of its current version
+type fakeVersioned[T any] struct {
+	value   T
+	version int
+}
+
+func (v *fakeVersioned[T]) etag() string { return strconv.Itoa(v.version) }
+
+func newFakeIncus() *fakeIncus {
+	return &fakeIncus{
+		projects: map[string]*fakeVersioned[api.Project]{
+			"default": {value: api.Project{Name: "default", ProjectPut: api.ProjectPut{Config: map[string]string{}}}},
+		},
+		profiles:  []string{"default"},
+		pools:     []string{"default"},
+		instances: map[string]*fakeVersioned[api.Instance]{},
+		volumes:   map[string]*api.StorageVolume{},
+		networks:  map[string]*api.Network{},
+		backups:   map[string]bool{},
+		failures:  map[string][]error{},
+	}
+}
+
+// connect returns the backend of the fake server for every remote
+func (f *fakeIncus) connect(remote string) (Backend, error) {
+	return &fakeBackend{fakeIncus: f, project: "default"}, nil
+}
+
+// failNext makes the next call of method fail with err
+func (f *fakeIncus) failNext(method string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[method] = append(f.failures[method], err)
+}
+
+// failWait makes the operation of the next call of method fail with err
+// once it's been submitted
+func (f *fakeIncus) failWait(method string, err error) {
+	f.failNext(method+" wait", err)
+}
+
+// waitFailure returns the injected failure of the operation of method, if
+// any. The caller holds the lock.
+func (f *fakeIncus) waitFailure(method string) error {
+	if errs := f.failures[method+" wait"]; len(errs) > 0 {
+		f.failures[method+" wait"] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+// called returns the recorded calls of method
+func (f *fakeIncus) called(method string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	calls := []string{}
+	for _, c := range f.calls {
+		if strings.HasPrefix(c, method+" ") {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// instance returns the instance name of the default project, nil when it
+// doesn't exist
+func (f *fakeIncus) instance(name string) *api.Instance {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inst, ok := f.instances["default/"+name]
+	if !ok {
+		return nil
+	}
+	return &inst.value
+}
+
+// call records a change and returns the injected failure of the method, if
+// any. The caller holds the lock.
+func (f *fakeIncus) call(method string, args ...string) error {
+	if errs := f.failures[method]; len(errs) > 0 {
+		f.failures[method] = errs[1:]
+		return errs[0]
+	}
+	f.calls = append(f.calls, strings.TrimSpace(method+" "+strings.Join(args, " ")))
+	return nil
+}
+
+func notFound(kind, name string) error {
+	return api.StatusErrorf(http.StatusNotFound, "%s %q not found", kind, name)
+}
+
+// fakeBackend is the Backend of a project of the fake server
+type fakeBackend struct {
+	*fakeIncus
+	project string
+}
+
+func (b *fakeBackend) key(name string) string { return b.project + "/" + name }
+
+func (b *fakeBackend) volumeKey(pool, volType, name string) string {
+	return b.project + "/" + pool + "/" + volType + "/" + name
+}
+
+func (b *fakeBackend) UseProject(name string) Backend {
+	return &fakeBackend{fakeIncus: b.fakeIncus, project: name}
+}
+
+func (b *fakeBackend) HasExtension(extension string) bool { return true }
+
+func (b *fakeBackend) GetImage(fingerprint string) (*api.Image, string, error) {
+	return &api.Image{Fingerprint: fingerprint, Type: "container"}, "", nil
+}
+
+func (b *fakeBackend) GetImageAlias(name string) (*api.ImageAliasesEntry, string, error) {
+	return &api.ImageAliasesEntry{Name: name, ImageAliasesEntryPut: api.ImageAliasesEntryPut{Target: "fp-" + name}}, "", nil
+}
+
+func (b *fakeBackend) GetInstances(instanceType api.InstanceType) ([]api.Instance, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instances := []api.Instance{}
+	for key, inst := range b.instances {
+		if strings.HasPrefix(key, b.project+"/") {
+			instances = append(instances, inst.value)
+		}
+	}
+	return instances, nil
+}
+
+func (b *fakeBackend) GetInstancesAllProjects(instanceType api.InstanceType) ([]api.Instance, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	instances := []api.Instance{}
+	for _, inst := range b.instances {
+		instances = append(instances, inst.value)
+	}
+	return instances, nil
+}
+
+func (b *fakeBackend) GetInstance(name string) (*api.Instance, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, ok := b.instances[b.key(name)]
+	if !ok {
+		return nil, "", notFound("instance", name)
+	}
+	copied := inst.value
+	copied.Config = cloneMap(inst.value.Config)
+	copied.Devices = map[string]map[string]string{}
+	for name, dev := range inst.value.Devices {
+		copied.Devices[name] = cloneMap(dev)
+	}
+	copied.Profiles = slices.Clone(inst.value.Profiles)
+	return &copied, inst.etag(), nil
+}
+
+func (b *fakeBackend) GetInstanceState(name string) (*api.InstanceState, string, error) {
+	inst, etag, err := b.GetInstance(name)
+	if err != nil {
+		return nil, "", err
+	}
+	return &api.InstanceState{Status: inst.Status, StatusCode: inst.StatusCode}, etag, nil
+}
+
+func (b *fakeBackend) CreateInstanceFromImage(source ImageSource, image api.Image, req api.InstancesPost) (incus.RemoteOperation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("CreateInstance", req.Name); err != nil {
+		return nil, err
+	}
+	if _, ok := b.instances[b.key(req.Name)]; ok {
+		return nil, api.StatusErrorf(http.StatusConflict, "instance %q already exists", req.Name)
+	}
+	inst := api.Instance{
+		Name:        req.Name,
+		Project:     b.project,
+		Type:        string(req.Type),
+		Status:      api.Stopped.String(),
+		StatusCode:  api.Stopped,
+		InstancePut: req.InstancePut,
+	}
+	if inst.Config == nil {
+		inst.Config = map[string]string{}
+	}
+	if inst.Devices == nil {
+		inst.Devices = map[string]map[string]string{}
+	}
+	b.instances[b.key(req.Name)] = &fakeVersioned[api.Instance]{value: inst}
+	return &fakeOperation{err: b.waitFailure("CreateInstance")}, nil
+}
+
+func (b *fakeBackend) RebuildInstanceFromImage(source ImageSource, image api.Image, instanceName string, req api.InstanceRebuildPost) (incus.RemoteOperation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[b.key(instanceName)]; !ok {
+		return nil, notFound("instance", instanceName)
+	}
+	return &fakeOperation{}, b.call("RebuildInstance", instanceName)
+}
+
+func (b *fakeBackend) UpdateInstance(name string, instance api.InstancePut, ETag string) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, ok := b.instances[b.key(name)]
+	if !ok {
+		return nil, notFound("instance", name)
+	}
+	if ETag != "" && ETag != inst.etag() {
+		return nil, api.StatusErrorf(http.StatusPreconditionFailed, "instance %q changed", name)
+	}
+	if err := b.call("UpdateInstance", name); err != nil {
+		return nil, err
+	}
+	inst.value.InstancePut = instance
+	inst.version++
+	return &fakeOperation{}, nil
+}
+
+func (b *fakeBackend) UpdateInstanceState(name string, state api.InstanceStatePut, ETag string) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, ok := b.instances[b.key(name)]
+	if !ok {
+		return nil, notFound("instance", name)
+	}
+	if state.Action == "start" && inst.value.StatusCode == api.Running {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "instance %q is already running", name)
+	}
+	if state.Action == "stop" && inst.value.StatusCode == api.Stopped {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "instance %q is already stopped", name)
+	}
+	if err := b.call("UpdateInstanceState", name, state.Action); err != nil {
+		return nil, err
+	}
+	switch state.Action {
+	case "start", "restart":
+		inst.value.StatusCode = api.Running
+	case "stop":
+		inst.value.StatusCode = api.Stopped
+	}
+	inst.value.Status = inst.value.StatusCode.String()
+	return &fakeOperation{}, nil
+}
+
+func (b *fakeBackend) DeleteInstance(name string) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	inst, ok := b.instances[b.key(name)]
+	if !ok {
+		return nil, notFound("instance", name)
+	}
+	if inst.value.StatusCode == api.Running {
+		return nil, api.StatusErrorf(http.StatusBadRequest, "instance %q is running", name)
+	}
+	if err := b.call("DeleteInstance", name); err != nil {
+		return nil, err
+	}
+	delete(b.instances, b.key(name))
+	return &fakeOperation{}, nil
+}
+
+func (b *fakeBackend) ExecInstance(instanceName string, exec api.InstanceExecPost, args *incus.InstanceExecArgs) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[b.key(instanceName)]; !ok {
+		return nil, notFound("instance", instanceName)
+	}
+	if err := b.call("ExecInstance", instanceName, strings.Join(exec.Command, " ")); err != nil {
+		return nil, err
+	}
+
+	stdout, code := "", 0
+	if b.exec != nil {
+		stdout, code = b.exec(instanceName, exec.Command)
+	}
+	if args.Stdout != nil {
+		_, _ = io.WriteString(args.Stdout, stdout)
+	}
+	if args.DataDone != nil {
+		close(args.DataDone)
+	}
+	return &fakeOperation{op: api.Operation{Metadata: map[string]any{"return": float64(code)}}}, nil
+}
+
+func (b *fakeBackend) GetInstanceConsoleLog(instanceName string, args *incus.InstanceConsoleLogArgs) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("")), nil
+}
+
+func (b *fakeBackend) CreateInstanceSnapshot(instanceName string, snapshot api.InstanceSnapshotsPost) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[b.key(instanceName)]; !ok {
+		return nil, notFound("instance", instanceName)
+	}
+	if slices.Contains(b.snapshots, instanceName+"/"+snapshot.Name) {
+		return nil, api.StatusErrorf(http.StatusConflict, "snapshot %q already exists", snapshot.Name)
+	}
+	if err := b.call("CreateInstanceSnapshot", instanceName, snapshot.Name); err != nil {
+		return nil, err
+	}
+	b.snapshots = append(b.snapshots, instanceName+"/"+snapshot.Name)
+	return &fakeOperation{}, nil
+}
+
+func (b *fakeBackend) CreateInstanceBackup(instanceName string, backup api.InstanceBackupsPost) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.instances[b.key(instanceName)]; !ok {
+		return nil, notFound("instance", instanceName)
+	}
+	if err := b.call("CreateInstanceBackup", instanceName); err != nil {
+		return nil, err
+	}
+	b.backups[instanceName+"/backup0"] = true
+	return backupOperation("/1.0/instances/" + instanceName + "/backups/backup0"), nil
+}
+
+func (b *fakeBackend) DeleteInstanceBackup(instanceName string, name string) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("DeleteInstanceBackup", instanceName, name); err != nil {
+		return nil, err
+	}
+	delete(b.backups, instanceName+"/"+name)
+	return &fakeOperation{}, nil
+}
+
+func (b *fakeBackend) GetInstanceBackupFile(instanceName string, name string, req *incus.BackupFileRequest) (*incus.BackupFileResponse, error) {
+	return b.backupFile(instanceName+"/"+name, req)
+}
+
+func (b *fakeBackend) GetStoragePoolNames() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.pools), nil
+}
+
+func (b *fakeBackend) GetStoragePool(name string) (*api.StoragePool, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !slices.Contains(b.pools, name) {
+		return nil, "", notFound("storage pool", name)
+	}
+	return &api.StoragePool{Name: name, Driver: "dir"}, "", nil
+}
+
+func (b *fakeBackend) GetStoragePoolVolumes(pool string) ([]api.StorageVolume, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	volumes := []api.StorageVolume{}
+	for key, vol := range b.volumes {
+		if strings.HasPrefix(key, b.project+"/"+pool+"/") {
+			volumes = append(volumes, *vol)
+		}
+	}
+	return volumes, nil
+}
+
+func (b *fakeBackend) GetStoragePoolVolume(pool string, volType string, name string) (*api.StorageVolume, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	vol, ok := b.volumes[b.volumeKey(pool, volType, name)]
+	if !ok {
+		return nil, "", notFound("storage volume", name)
+	}
+	copied := *vol
+	copied.Config = cloneMap(vol.Config)
+	return &copied, "", nil
+}
+
+func (b *fakeBackend) CreateStoragePoolVolume(pool string, volume api.StorageVolumesPost) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := b.volumeKey(pool, volume.Type, volume.Name)
+	if _, ok := b.volumes[key]; ok {
+		return api.StatusErrorf(http.StatusConflict, "storage volume %q already exists", volume.Name)
+	}
+	if err := b.call("CreateStoragePoolVolume", pool, volume.Name); err != nil {
+		return err
+	}
+	b.volumes[key] = &api.StorageVolume{
+		Name:             volume.Name,
+		Type:             volume.Type,
+		Project:          b.project,
+		StorageVolumePut: volume.StorageVolumePut,
+	}
+	return nil
+}
+
+func (b *fakeBackend) UpdateStoragePoolVolume(pool string, volType string, name string, volume api.StorageVolumePut, ETag string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	vol, ok := b.volumes[b.volumeKey(pool, volType, name)]
+	if !ok {
+		return notFound("storage volume", name)
+	}
+	if err := b.call("UpdateStoragePoolVolume", pool, name); err != nil {
+		return err
+	}
+	vol.StorageVolumePut = volume
+	return nil
+}
+
+func (b *fakeBackend) DeleteStoragePoolVolume(pool string, volType string, name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	key := b.volumeKey(pool, volType, name)
+	if _, ok := b.volumes[key]; !ok {
+		return notFound("storage volume", name)
+	}
+	if err := b.call("DeleteStoragePoolVolume", pool, name); err != nil {
+		return err
+	}
+	delete(b.volumes, key)
+	return nil
+}
+
+func (b *fakeBackend) CreateStoragePoolVolumeSnapshot(pool string, volumeType string, volumeName string, snapshot api.StorageVolumeSnapshotsPost) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.volumes[b.volumeKey(pool, volumeType, volumeName)]; !ok {
+		return nil, notFound("storage volume", volumeName)
+	}
+	if slices.Contains(b.snapshots, volumeName+"/"+snapshot.Name) {
+		return nil, api.StatusErrorf(http.StatusConflict, "snapshot %q already exists", snapshot.Name)
+	}
+	if err := b.call("CreateStoragePoolVolumeSnapshot", pool, volumeName, snapshot.Name); err != nil {
+		return nil, err
+	}
+	b.snapshots = append(b.snapshots, volumeName+"/"+snapshot.Name)
+	return &fakeOperation{}, nil
+}
+
+func (b *fakeBackend) CreateStorageVolumeBackup(pool string, volName string, backup api.StorageVolumeBackupsPost) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.volumes[b.volumeKey(pool, "custom", volName)]; !ok {
+		return nil, notFound("storage volume", volName)
+	}
+	if err := b.call("CreateStorageVolumeBackup", pool, volName); err != nil {
+		return nil, err
+	}
+	b.backups[volName+"/backup0"] = true
+	return backupOperation("/1.0/storage-pools/" + pool + "/volumes/custom/" + volName + "/backups/backup0"), nil
+}
+
+func (b *fakeBackend) DeleteStorageVolumeBackup(pool string, volName string, name string) (incus.Operation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("DeleteStorageVolumeBackup", pool, volName, name); err != nil {
+		return nil, err
+	}
+	delete(b.backups, volName+"/"+name)
+	return &fakeOperation{}, nil
+}
+
+func (b *fakeBackend) GetStorageVolumeBackupFile(pool string, volName string, name string, req *incus.BackupFileRequest) (*incus.BackupFileResponse, error) {
+	return b.backupFile(volName+"/"+name, req)
+}
+
+// backupFile writes the content of a backup, which is its name
+func (b *fakeBackend) backupFile(key string, req *incus.BackupFileRequest) (*incus.BackupFileResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.backups[key] {
+		return nil, notFound("backup", key)
+	}
+	n, err := io.WriteString(req.BackupFile, key)
+	if err != nil {
+		return nil, err
+	}
+	return &incus.BackupFileResponse{Size: int64(n)}, nil
+}
+
+func (b *fakeBackend) GetNetworkNames() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := []string{}
+	for name := range b.networks {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (b *fakeBackend) GetNetworks() ([]api.Network, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	networks := []api.Network{}
+	for _, network := range b.networks {
+		networks = append(networks, *network)
+	}
+	return networks, nil
+}
+
+func (b *fakeBackend) GetNetwork(name string) (*api.Network, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	network, ok := b.networks[name]
+	if !ok {
+		return nil, "", notFound("network", name)
+	}
+	copied := *network
+	copied.Config = cloneMap(network.Config)
+	return &copied, "", nil
+}
+
+func (b *fakeBackend) CreateNetwork(network api.NetworksPost) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.networks[network.Name]; ok {
+		return api.StatusErrorf(http.StatusConflict, "network %q already exists", network.Name)
+	}
+	if err := b.call("CreateNetwork", network.Name); err != nil {
+		return err
+	}
+	b.networks[network.Name] = &api.Network{
+		Name:       network.Name,
+		Type:       network.Type,
+		Managed:    true,
+		NetworkPut: network.NetworkPut,
+	}
+	return nil
+}
+
+func (b *fakeBackend) DeleteNetwork(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.networks[name]; !ok {
+		return notFound("network", name)
+	}
+	if err := b.call("DeleteNetwork", name); err != nil {
+		return err
+	}
+	delete(b.networks, name)
+	return nil
+}
+
+func (b *fakeBackend) GetProjectNames() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := []string{}
+	for name := range b.projects {
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func (b *fakeBackend) GetProject(name string) (*api.Project, string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.projects[name]
+	if !ok {
+		return nil, "", notFound("project", name)
+	}
+	copied := p.value
+	copied.Config = cloneMap(p.value.Config)
+	return &copied, p.etag(), nil
+}
+
+func (b *fakeBackend) UpdateProject(name string, project api.ProjectPut, ETag string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.projects[name]
+	if !ok {
+		return notFound("project", name)
+	}
+	if ETag != "" && ETag != p.etag() {
+		return api.StatusErrorf(http.StatusPreconditionFailed, "project %q changed", name)
+	}
+	if err := b.call("UpdateProject", name); err != nil {
+		return err
+	}
+	p.value.ProjectPut = project
+	p.version++
+	return nil
+}
+
+func (b *fakeBackend) GetProfileNames() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.profiles), nil
+}
+
+func cloneMap(m map[string]string) map[string]string {
+	cloned := make(map[string]string, len(m))
+	for k, v := range m {
+		cloned[k] = v
+	}
+	return cloned
+}
+
+// fakeOperation is an operation that already completed, with err
+type fakeOperation struct {
+	op  api.Operation
+	err error
+}
+
+func backupOperation(backup string) *fakeOperation {
+	return &fakeOperation{op: api.Operation{Resources: map[string][]string{"backups": {backup}}}}
+}
+
+func (o *fakeOperation) AddHandler(function func(api.Operation)) (*incus.EventTarget, error) {
+	return nil, nil
+}
+
+func (o *fakeOperation) Cancel() error { return nil }
+
+func (o *fakeOperation) CancelTarget() error { return nil }
+
+func (o *fakeOperation) Get() api.Operation { return o.op }
+
+func (o *fakeOperation) GetTarget() (*api.Operation, error) { return &o.op, nil }
+
+func (o *fakeOperation) GetWebsocket(secret string) (*websocket.Conn, error) {
+	return nil, errors.New("websockets aren't supported by the fake")
+}
+
+func (o *fakeOperation) RemoveHandler(target *incus.EventTarget) error { return nil }
+
+func (o *fakeOperation) Refresh() error { return nil }
+
+func (o *fakeOperation) Wait() error { return o.err }
+
+func (o *fakeOperation) WaitContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("operation: %w", err)
+	}
+	return o.err
+}
@@ -0,0 +1,68 @@
+package application
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Hook is told about every action applied to the stack while a command
+// runs. Hooks are called concurrently when services are processed in
+// parallel.
+type Hook interface {
+	Applied(action Action)
+}
+
+// SubmitHook is implemented by hooks that also want to know about actions
+// that failed after their change was submitted to the server, like an
+// instance whose creation didn't complete. The change may or may not have
+// been made.
+type SubmitHook interface {
+	Submitted(action Action)
+}
+
+// AddHook registers a hook for the actions applied from now on
+func (app *Compose) AddHook(h Hook) {
+	app.hooks = append(app.hooks, h)
+}
+
+// RemoveHook unregisters a hook
+func (app *Compose) RemoveHook(h Hook) {
+	app.hooks = slices.DeleteFunc(app.hooks, func(other Hook) bool { return other == h })
+}
+
+// applied tells the hooks about an action that was just applied, start is
+// when applying it started
+func (app *Compose) applied(start time.Time, action Action) {
+	action.Duration = time.Since(start)
+	action.apply = nil
+	for _, h := range app.hooks {
+		h.Applied(action)
+	}
+}
+
+// submitted tells the hooks implementing SubmitHook about an action that
+// failed after its change was submitted
+func (app *Compose) submitted(action Action) {
+	action.apply = nil
+	for _, h := range app.hooks {
+		if sh, ok := h.(SubmitHook); ok {
+			sh.Submitted(action)
+		}
+	}
+}
+
+type submitKey struct{}
+
+// withSubmit returns a context in which markSubmitted calls fn
+func withSubmit(ctx context.Context, fn func()) context.Context {
+	return context.WithValue(ctx, submitKey{}, fn)
+}
+
+// markSubmitted records that the change of the action being applied with
+// ctx was accepted by the server, before waiting for it to complete
+func markSubmitted(ctx context.Context) {
+	if fn, ok := ctx.Value(submitKey{}).(func()); ok {
+		fn()
+	}
+}
@@ -5,13 +5,12 @@ import (
 	"os"
 	"strings"
 
-	incus "github.com/lxc/incus/v6/client"
 	"github.com/lxc/incus/v6/shared/api"
 	config "github.com/lxc/incus/v6/shared/cliconfig"
 )
 
 // guessImage checks that the image name (provided by the user) is correct given an instance remote and image remote.
-func guessImage(conf *config.Config, d incus.InstanceServer, instRemote string, imgRemote string, imageRef string) (string, string) {
+func guessImage(conf *config.Config, d Backend, instRemote string, imgRemote string, imageRef string) (string, string) {
 	if instRemote != imgRemote {
 		return imgRemote, imageRef
 	}
@@ -43,8 +42,8 @@ func guessImage(conf *config.Config, d incus.InstanceServer, instRemote string,
 
 // getImgInfo returns an image server and image info for the given image name (given by a user)
 // an image remote and an instance remote.
-func getImgInfo(d incus.InstanceServer, conf *config.Config, imgRemote string, instRemote string, imageRef string, source *api.InstanceSource) (incus.ImageServer, *api.Image, error) {
-	var imgRemoteServer incus.ImageServer
+func getImgInfo(d Backend, conf *config.Config, imgRemote string, instRemote string, imageRef string, source *api.InstanceSource) (ImageSource, *api.Image, error) {
+	var imgRemoteServer ImageSource
 	var imgInfo *api.Image
 	var err error
 
@@ -81,3 +80,39 @@ func getImgInfo(d incus.InstanceServer, conf *config.Config, imgRemote string, i
 
 	return imgRemoteServer, imgInfo, nil
 }
+
+// resolveImage returns the image server and image info for an image reference
+// from the compose file, filling in the instance source.
+func (app *Compose) resolveImage(d Backend, remote string, imageRef string, source *api.InstanceSource) (ImageSource, *api.Image, error) {
+	iremote, image, err := app.conf.ParseRemote(imageRef)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iremote, image = guessImage(app.conf, d, remote, iremote, image)
+	// Deal with the default image
+	if image == "" {
+		image = "default"
+	}
+	imgRemote, imgInfo, err := getImgInfo(d, app.conf, iremote, remote, image, source)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return imgRemote, imgInfo, nil
+}
+
+// imageFingerprint returns the fingerprint (or OCI digest) the image currently
+// points to. Images from public image servers are only known by their alias,
+// so the alias is resolved against the image server.
+func imageFingerprint(imgServer ImageSource, imgInfo *api.Image, source api.InstanceSource) (string, error) {
+	if source.Alias == "" || imgInfo.Fingerprint != source.Alias {
+		return imgInfo.Fingerprint, nil
+	}
+
+	alias, _, err := imgServer.GetImageAlias(source.Alias)
+	if err != nil {
+		return "", fmt.Errorf("failed resolving image %q: %w", source.Alias, err)
+	}
+	return alias.Target, nil
+}
@@ -0,0 +1,261 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+)
+
+// stateDir holds what incus-compose keeps between runs, next to .secrets
+const stateDir = ".incus-compose"
+
+// JournalPath is where the journal of the last up or down run of the
+// compose project in dir is kept
+func JournalPath(dir string) string {
+	return filepath.Join(dir, stateDir, "journal.json")
+}
+
+// JournalOptions are the options of the journaled run, used to resume it
+type JournalOptions struct {
+	Policy            RecreatePolicy `json:"policy,omitempty"`
+	RemoveOrphans     bool           `json:"remove_orphans,omitempty"`
+	RollbackOnFailure bool           `json:"rollback_on_failure,omitempty"`
+	Force             bool           `json:"force,omitempty"`
+	Volumes           bool           `json:"volumes,omitempty"`
+	Timeout           int            `json:"timeout,omitempty"`
+}
+
+// Journal records the planned and completed actions of an up or down run,
+// so a run that was interrupted can be reported and resumed.
+type Journal struct {
+	mu   sync.Mutex
+	path string
+
+	Stack      string         `json:"stack"`
+	Command    string         `json:"command"`
+	Services   []string       `json:"services,omitempty"`
+	Options    JournalOptions `json:"options"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Resumed    int            `json:"resumed,omitempty"`
+	Planned    []Action       `json:"planned"`
+	Completed  []Action       `json:"completed"`
+}
+
+// Complete reports whether the run finished without error
+func (j *Journal) Complete() bool {
+	return j.FinishedAt != nil && j.Error == ""
+}
+
+// State is complete, failed, or incomplete for a run that never finished
+// because it was killed or crashed.
+func (j *Journal) State() string {
+	switch {
+	case j.FinishedAt == nil:
+		return "incomplete"
+	case j.Error != "":
+		return "failed"
+	default:
+		return "complete"
+	}
+}
+
+// Remaining returns the planned actions that weren't completed
+func (j *Journal) Remaining() []Action {
+	remaining := []Action{}
+	for _, p := range j.Planned {
+		done := slices.ContainsFunc(j.Completed, func(c Action) bool {
+			return c.Verb == p.Verb && c.Kind == p.Kind && c.Name == p.Name
+		})
+		if !done {
+			remaining = append(remaining, p)
+		}
+	}
+	return remaining
+}
+
+func (j *Journal) Applied(action Action) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Completed = append(j.Completed, action)
+	err := j.save()
+	if err != nil {
+		slog.Warn("Journal not saved", slog.String("error", err.Error()))
+	}
+}
+
+// save writes the journal, replacing the previous one atomically. The
+// caller holds the lock.
+func (j *Journal) save() error {
+	err := os.MkdirAll(filepath.Dir(j.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	bb, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := j.path + ".tmp"
+	err = os.WriteFile(tmp, bb, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, j.path)
+}
+
+func (j *Journal) finish(runErr error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.FinishedAt = &now
+	j.Error = ""
+	if runErr != nil {
+		j.Error = runErr.Error()
+	}
+	return j.save()
+}
+
+// LoadJournal reads the journal of the last run of the compose project in
+// dir, it returns nil when there is none.
+func LoadJournal(dir string) (*Journal, error) {
+	path := JournalPath(dir)
+	bb, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	j := &Journal{path: path}
+	err = json.Unmarshal(bb, j)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// journaled runs fn while recording its actions in the journal. The plan,
+// when given, is recorded first; failing to compute it doesn't stop the run.
+func (app *Compose) journaled(j *Journal, plan func() (*Plan, error), fn func() error) error {
+	if plan != nil {
+		p, err := plan()
+		if err != nil {
+			slog.Warn("Journal without plan", slog.String("error", err.Error()))
+		} else {
+			j.Planned = p.Actions
+		}
+	}
+
+	j.mu.Lock()
+	err := j.save()
+	j.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed writing journal: %w", err)
+	}
+
+	app.AddHook(j)
+	defer app.RemoveHook(j)
+
+	runErr := fn()
+	err = j.finish(runErr)
+	if err != nil {
+		slog.Warn("Journal not saved", slog.String("error", err.Error()))
+	}
+	return runErr
+}
+
+func (app *Compose) newJournal(command string, services []string, options JournalOptions) *Journal {
+	return &Journal{
+		path:      JournalPath(app.ComposeProject.WorkingDir),
+		Stack:     app.Name,
+		Command:   command,
+		Services:  services,
+		Options:   options,
+		StartedAt: time.Now(),
+		Planned:   []Action{},
+		Completed: []Action{},
+	}
+}
+
+// resumable loads the journal of the last run of command that didn't
+// complete.
+func (app *Compose) resumable(command string) (*Journal, error) {
+	j, err := LoadJournal(app.ComposeProject.WorkingDir)
+	if err != nil {
+		return nil, err
+	}
+	if j == nil {
+		return nil, fmt.Errorf("%w: no run to resume", types.ErrNotFound)
+	}
+	if j.Stack != app.Name {
+		return nil, fmt.Errorf("%w: the journal belongs to stack %s", types.ErrConflict, j.Stack)
+	}
+	if j.Command != command {
+		return nil, fmt.Errorf("%w: the last run was %s, not %s", types.ErrConflict, j.Command, command)
+	}
+	if j.Complete() {
+		return nil, fmt.Errorf("%w: the last %s run completed, nothing to resume", types.ErrConflict, j.Command)
+	}
+	return j, nil
+}
+
+// PlanResume returns the planned actions of the interrupted run of command
+// that weren't completed.
+func (app *Compose) PlanResume(ctx context.Context, command string) (*Plan, error) {
+	j, err := app.resumable(command)
+	if err != nil {
+		return nil, err
+	}
+	return &Plan{Actions: j.Remaining()}, nil
+}
+
+// Resume continues the up or down run recorded in the journal that didn't
+// complete, with the services and options it was started with. The steps
+// of a run skip what already exists or is already gone, so the completed
+// actions aren't repeated. Instances recreated before the interruption
+// aren't recreated again.
+func (app *Compose) Resume(ctx context.Context, command string) error {
+	j, err := app.resumable(command)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Resuming", slog.String("command", j.Command), slog.Int("completed", len(j.Completed)), slog.Int("remaining", len(j.Remaining())))
+
+	j.Resumed++
+	j.FinishedAt = nil
+	j.Error = ""
+	opts := j.Options
+
+	switch j.Command {
+	case "up":
+		app.recreated = map[string]bool{}
+		for _, a := range j.Completed {
+			if a.Kind == "instance" && (a.Verb == "create" || a.Verb == "recreate") {
+				app.recreated[a.Service] = true
+			}
+		}
+		return app.journaled(j, nil, func() error {
+			return app.up(ctx, j.Services, opts.Policy, opts.RemoveOrphans, opts.RollbackOnFailure)
+		})
+	case "down":
+		return app.journaled(j, nil, func() error {
+			return app.down(ctx, opts.Force, opts.Volumes, opts.RemoveOrphans, opts.Timeout)
+		})
+	default:
+		return fmt.Errorf("can't resume %s", j.Command)
+	}
+}
@@ -0,0 +1,123 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+)
+
+func TestJournalInProjectDirectory(t *testing.T) {
+	app, _ := newTestApp(t, testCompose)
+	// run from another directory, like with --cwd
+	t.Chdir(t.TempDir())
+
+	err := app.Up(context.Background(), nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if _, err := os.Stat(JournalPath("")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("journal written to the current directory: %v", err)
+	}
+	j, err := LoadJournal(app.ComposeProject.WorkingDir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if j == nil || j.Command != "up" || !j.Complete() {
+		t.Errorf("journal: %+v", j)
+	}
+}
+
+func TestJournalRemaining(t *testing.T) {
+	j := &Journal{
+		Planned: []Action{
+			{Verb: "create", Kind: "network", Name: "shop"},
+			{Verb: "create", Kind: "instance", Name: "db"},
+			{Verb: "start", Kind: "instance", Name: "db"},
+		},
+		Completed: []Action{
+			{Verb: "create", Kind: "network", Name: "shop"},
+			// the same name and kind but another verb
+			{Verb: "stop", Kind: "instance", Name: "db"},
+		},
+	}
+	if got := summary(j.Remaining()); !slices.Equal(got, []string{"create instance db", "start instance db"}) {
+		t.Errorf("remaining: %v", got)
+	}
+
+	j.Completed = append(j.Completed, Action{Verb: "create", Kind: "instance", Name: "db"}, Action{Verb: "start", Kind: "instance", Name: "db"})
+	if got := j.Remaining(); len(got) != 0 {
+		t.Errorf("remaining after completion: %v", summary(got))
+	}
+}
+
+func TestResume(t *testing.T) {
+	ctx := context.Background()
+	app, fake := newTestApp(t, testCompose)
+
+	_, err := app.PlanResume(ctx, "up")
+	if !errors.Is(err, types.ErrNotFound) {
+		t.Errorf("plan resume without journal: %v, want not found", err)
+	}
+
+	fake.failNext("CreateInstance", errors.New("no space left"))
+	err = app.Up(ctx, nil, RecreateNever, false, false)
+	if err == nil {
+		t.Fatal("up succeeded")
+	}
+	j, err := LoadJournal(app.ComposeProject.WorkingDir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if j.State() != "failed" {
+		t.Errorf("state: %s, want failed", j.State())
+	}
+
+	want := []string{
+		"create instance db",
+		"attach device db/shop-db-data",
+		"create instance web",
+		"start instance db",
+		"start instance web",
+	}
+	plan, err := app.PlanResume(ctx, "up")
+	if err != nil {
+		t.Fatalf("plan resume: %v", err)
+	}
+	if got := summary(plan.Actions); !slices.Equal(got, want) {
+		t.Errorf("remaining: %v, want %v", got, want)
+	}
+	_, err = app.PlanResume(ctx, "down")
+	if !errors.Is(err, types.ErrConflict) {
+		t.Errorf("plan resume of down: %v, want a conflict", err)
+	}
+
+	err = app.Resume(ctx, "up")
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if got := fake.called("CreateNetwork"); len(got) != 1 {
+		t.Errorf("networks created: %v", got)
+	}
+	if got := fake.called("CreateInstance"); !slices.Equal(got, []string{"CreateInstance db", "CreateInstance web"}) {
+		t.Errorf("instances created: %v", got)
+	}
+	j, err = LoadJournal(app.ComposeProject.WorkingDir)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !j.Complete() || j.Resumed != 1 {
+		t.Errorf("journal after resume: %s, resumed %d times", j.State(), j.Resumed)
+	}
+	if got := j.Remaining(); len(got) != 0 {
+		t.Errorf("remaining after resume: %v", summary(got))
+	}
+
+	err = app.Resume(ctx, "up")
+	if !errors.Is(err, types.ErrConflict) {
+		t.Errorf("resume of a complete run: %v, want a conflict", err)
+	}
+}
@@ -0,0 +1,63 @@
+package application
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+// Keys tagging the instances, custom volumes and networks incus-compose
+// creates, so they can be traced back to their stack and service.
+const (
+	// labelKey is set to true on everything incus-compose creates
+	labelKey = "user.dev.brian.incus-compose"
+	// directoryKey is the working directory of the compose project
+	directoryKey = labelKey + ".directory"
+	// stackKey is the name of the stack
+	stackKey = labelKey + ".stack"
+	// serviceKey is the service an instance or volume belongs to
+	serviceKey = labelKey + ".service"
+	// configHashKey is a hash of the configuration the resource was
+	// created from
+	configHashKey = labelKey + ".config-hash"
+	// environmentKey lists the environment variables set from the compose file
+	environmentKey = labelKey + ".environment"
+	// devicesKey lists the devices added from the compose file
+	devicesKey = labelKey + ".devices"
+	// imageKey is the image an instance was created from
+	imageKey = labelKey + ".image"
+	// runKey is set on the one-off instances of run, which carry the
+	// labels of their service but aren't part of the stack
+	runKey = labelKey + ".run"
+)
+
+// stackLabels returns the keys tagging a resource of the stack created from
+// config. service is empty for resources shared by the whole stack.
+func (app *Compose) stackLabels(service string, config any) (map[string]string, error) {
+	hash, err := configHash(config)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{
+		labelKey:      "true",
+		directoryKey:  app.ComposeProject.WorkingDir,
+		stackKey:      app.Name,
+		configHashKey: hash,
+	}
+	if service != "" {
+		labels[serviceKey] = service
+	}
+	return labels, nil
+}
+
+// configHash returns a stable hash of a configuration
+func configHash(config any) (string, error) {
+	b, err := json.Marshal(config)
+	if err != nil {
+		return "", fmt.Errorf("failed hashing configuration: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,104 @@
+package application
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// composeFiles are the parsed compose files of a project, used to locate
+// the elements errors are about
+type composeFiles struct {
+	files []string
+	docs  []*yaml.Node
+}
+
+// readComposeFiles reads the compose files. Files that can't be read are
+// skipped, errors in them are reported without a line.
+func readComposeFiles(files []string) *composeFiles {
+	c := &composeFiles{}
+	for _, file := range files {
+		bb, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var doc yaml.Node
+		if yaml.Unmarshal(bb, &doc) != nil {
+			continue
+		}
+		c.files = append(c.files, file)
+		c.docs = append(c.docs, &doc)
+	}
+	return c
+}
+
+// errorAt returns a validation error about the element at path
+func (c *composeFiles) errorAt(path []string, err error) *types.ValidationError {
+	file, line, column := c.locate(path)
+	return &types.ValidationError{
+		File:   file,
+		Line:   line,
+		Column: column,
+		Path:   strings.Join(path, "."),
+		Err:    err,
+	}
+}
+
+// locate finds the key at path, sequence items are given by their index. An
+// element that isn't found is located at its closest parent.
+func (c *composeFiles) locate(path []string) (file string, line, column int) {
+	for i, doc := range c.docs {
+		if len(doc.Content) == 0 {
+			continue
+		}
+		node := doc.Content[0]
+		found := false
+		for _, segment := range path {
+			next := childNode(node, segment)
+			if next == nil {
+				break
+			}
+			found = true
+			node = next
+			line, column = node.Line, node.Column
+		}
+		if found {
+			return c.files[i], line, column
+		}
+	}
+	if len(c.files) > 0 {
+		return c.files[0], 0, 0
+	}
+	return "", 0, 0
+}
+
+// childNode returns the key of a mapping, or the item of a sequence, named
+// by segment. Items of a sequence are named by their index or, for short
+// syntax lists like the networks of a service, by their value.
+func childNode(node *yaml.Node, segment string) *yaml.Node {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == segment {
+				// the key locates the element, the value is walked
+				key, value := *node.Content[i], node.Content[i+1]
+				key.Kind, key.Content = value.Kind, value.Content
+				return &key
+			}
+		}
+	case yaml.SequenceNode:
+		i, err := strconv.Atoi(segment)
+		if err == nil && i >= 0 && i < len(node.Content) {
+			return node.Content[i]
+		}
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode && item.Value == segment {
+				return item
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// lockPollInterval is how often a waiting run checks whether the lock was
+// released
+const lockPollInterval = 2 * time.Second
+
+// LockHolder describes the run holding the lock of a stack
+type LockHolder struct {
+	User    string    `json:"user"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+func (h LockHolder) String() string {
+	return fmt.Sprintf("%s on %s (pid %d, %s since %s)", h.User, h.Host, h.PID, h.Command, h.Started.Format(time.RFC3339))
+}
+
+// LockedError is returned when another run holds the lock of the stack
+type LockedError struct {
+	Stack  string
+	Holder LockHolder
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("stack %s is locked by %s", e.Stack, e.Holder)
+}
+
+func (e *LockedError) Is(target error) bool { return target == types.ErrConflict }
+
+// lockKey is the project key holding the lock of the stack. Projects are
+// shared by stacks, so the key is named after the stack.
+func (app *Compose) lockKey() string {
+	return labelKey + ".lock." + app.Name
+}
+
+// Lock takes the advisory lock of the stack, a user key on its project
+// written with compare-and-set so two runs can't both take it. It fails when
+// another run holds the lock, unless wait is set, in which case it waits for
+// the lock until ctx is done. The returned function releases the lock.
+func (app *Compose) Lock(ctx context.Context, command string, wait bool) (func() error, error) {
+	holder, err := newLockHolder(command)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(holder)
+	if err != nil {
+		return nil, err
+	}
+
+	waiting := false
+	for {
+		_, err := app.updateLock(func(existing *LockHolder) (string, error) {
+			if existing != nil {
+				return "", &LockedError{Stack: app.Name, Holder: *existing}
+			}
+			return string(value), nil
+		})
+		if err == nil {
+			slog.Debug("Lock taken", slog.String("stack", app.Name))
+			break
+		}
+
+		var locked *LockedError
+		if !wait || !errors.As(err, &locked) {
+			return nil, err
+		}
+		if !waiting {
+			slog.Info("Waiting for lock", slog.String("stack", app.Name), slog.String("holder", locked.Holder.String()))
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for lock: %w", context.Cause(ctx))
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	unlock := func() error {
+		_, err := app.updateLock(func(existing *LockHolder) (string, error) {
+			if existing == nil || !existing.same(holder) {
+				// broken and maybe taken by another run since
+				slog.Warn("Lock no longer held", slog.String("stack", app.Name))
+				return lockUnchanged, nil
+			}
+			return "", nil
+		})
+		return err
+	}
+	return unlock, nil
+}
+
+// LockHolder returns the run holding the lock of the stack, nil when the
+// stack isn't locked.
+func (app *Compose) LockHolder() (*LockHolder, error) {
+	d, project, err := app.lockProject()
+	if err != nil {
+		return nil, err
+	}
+	p, _, err := d.GetProject(project)
+	if err != nil {
+		return nil, err
+	}
+	return parseLockHolder(p.Config[app.lockKey()])
+}
+
+// BreakLock removes the lock of the stack whoever holds it
+func (app *Compose) BreakLock() (*LockHolder, error) {
+	var broken *LockHolder
+	_, err := app.updateLock(func(existing *LockHolder) (string, error) {
+		broken = existing
+		if existing == nil {
+			return lockUnchanged, nil
+		}
+		return "", nil
+	})
+	return broken, err
+}
+
+// lockUnchanged tells updateLock to leave the lock as it is
+const lockUnchanged = "\x00"
+
+// updateLock sets the lock key to what update returns for the current
+// holder, an empty value removes it. The project is updated with its etag
+// and the update is retried when the project changed in between. The
+// current holder is returned with update's error.
+func (app *Compose) updateLock(update func(existing *LockHolder) (string, error)) (*LockHolder, error) {
+	d, project, err := app.lockProject()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p, etag, err := d.GetProject(project)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading project %q: %w", project, err)
+		}
+		existing, err := parseLockHolder(p.Config[app.lockKey()])
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := update(existing)
+		if err != nil || value == lockUnchanged {
+			return existing, err
+		}
+
+		put := p.Writable()
+		if put.Config == nil {
+			put.Config = map[string]string{}
+		}
+		if value == "" {
+			delete(put.Config, app.lockKey())
+		} else {
+			put.Config[app.lockKey()] = value
+		}
+
+		err = d.UpdateProject(project, put, etag)
+		if api.StatusErrorCheck(err, http.StatusPreconditionFailed) {
+			slog.Debug("Project changed, retrying", slog.String("project", project))
+			continue
+		}
+		if err != nil {
+			return existing, fmt.Errorf("failed updating project %q: %w", project, err)
+		}
+		return existing, nil
+	}
+}
+
+func (app *Compose) lockProject() (Backend, string, error) {
+	resources, err := app.ParseServers(app.Name)
+	if err != nil {
+		return nil, "", err
+	}
+	return resources[0].server, app.GetProject(), nil
+}
+
+func parseLockHolder(value string) (*LockHolder, error) {
+	if value == "" {
+		return nil, nil
+	}
+	holder := &LockHolder{}
+	err := json.Unmarshal([]byte(value), holder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stack lock %q: %w", value, err)
+	}
+	return holder, nil
+}
+
+func newLockHolder(command string) (*LockHolder, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return &LockHolder{
+		User:    name,
+		Host:    host,
+		PID:     os.Getpid(),
+		Command: command,
+		Started: time.Now().Truncate(time.Second),
+	}, nil
+}
+
+// same reports whether two holders are the same run
+func (h *LockHolder) same(other *LockHolder) bool {
+	return h.User == other.User && h.Host == other.Host && h.PID == other.PID &&
+		h.Command == other.Command && h.Started.Equal(other.Started)
+}
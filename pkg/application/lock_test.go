@@ -0,0 +1,159 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	app, fake := newTestApp(t, testCompose)
+
+	unlock, err := app.Lock(ctx, "up", false)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	holder, err := app.LockHolder()
+	if err != nil {
+		t.Fatalf("holder: %v", err)
+	}
+	if holder == nil || holder.Command != "up" {
+		t.Fatalf("holder: %v", holder)
+	}
+
+	_, err = app.Lock(ctx, "down", false)
+	var locked *LockedError
+	if !errors.As(err, &locked) || !errors.Is(err, types.ErrConflict) || locked.Holder.Command != "up" {
+		t.Errorf("second lock: %v, want locked by up", err)
+	}
+
+	// stacks sharing the project have their own lock
+	other, _ := newTestApp(t, "name: other\nservices:\n  web:\n    image: alpine\n")
+	other.connect = fake.connect
+	unlockOther, err := other.Lock(ctx, "up", false)
+	if err != nil {
+		t.Fatalf("lock of another stack: %v", err)
+	}
+	defer unlockOther()
+
+	err = unlock()
+	if err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	holder, err = app.LockHolder()
+	if err != nil || holder != nil {
+		t.Errorf("holder after unlock: %v, %v", holder, err)
+	}
+	if holder, _ := other.LockHolder(); holder == nil {
+		t.Error("lock of another stack released")
+	}
+
+	unlock, err = app.Lock(ctx, "down", false)
+	if err != nil {
+		t.Fatalf("lock after unlock: %v", err)
+	}
+	_ = unlock()
+}
+
+func TestLockContention(t *testing.T) {
+	ctx := context.Background()
+	app, _ := newTestApp(t, testCompose)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := app.Lock(ctx, "up", false)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	taken := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			taken++
+		case !errors.Is(err, types.ErrConflict):
+			t.Errorf("lock: %v", err)
+		}
+	}
+	if taken != 1 {
+		t.Errorf("lock taken %d times", taken)
+	}
+}
+
+func TestLockRetriesChangedProject(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+	fake.failNext("UpdateProject", api.StatusErrorf(http.StatusPreconditionFailed, "changed"))
+
+	_, err := app.Lock(context.Background(), "up", false)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	if holder, _ := app.LockHolder(); holder == nil {
+		t.Error("lock not taken")
+	}
+}
+
+func TestBreakLock(t *testing.T) {
+	ctx := context.Background()
+	app, _ := newTestApp(t, testCompose)
+
+	broken, err := app.BreakLock()
+	if err != nil || broken != nil {
+		t.Errorf("break of no lock: %v, %v", broken, err)
+	}
+
+	unlockUp, err := app.Lock(ctx, "up", false)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	broken, err = app.BreakLock()
+	if err != nil {
+		t.Fatalf("break: %v", err)
+	}
+	if broken == nil || broken.Command != "up" {
+		t.Errorf("broken: %v", broken)
+	}
+
+	unlockDown, err := app.Lock(ctx, "down", false)
+	if err != nil {
+		t.Fatalf("lock after break: %v", err)
+	}
+	// the run whose lock was broken doesn't release the new one
+	err = unlockUp()
+	if err != nil {
+		t.Fatalf("unlock of the broken lock: %v", err)
+	}
+	holder, _ := app.LockHolder()
+	if holder == nil || holder.Command != "down" {
+		t.Errorf("holder: %v, want down", holder)
+	}
+	_ = unlockDown()
+}
+
+func TestLockWaitCancelled(t *testing.T) {
+	app, _ := newTestApp(t, testCompose)
+	_, err := app.Lock(context.Background(), "up", false)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = app.Lock(ctx, "down", true)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wait: %v, want deadline exceeded", err)
+	}
+}
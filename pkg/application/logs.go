@@ -0,0 +1,227 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/bketelsen/incus-compose/pkg/ui"
+	incus "github.com/lxc/incus/v6/client"
+	api "github.com/lxc/incus/v6/shared/api"
+	"github.com/lxc/incus/v6/shared/util"
+)
+
+// LogOptions controls which log lines are shown.
+type LogOptions struct {
+	// Follow keeps streaming new log lines until interrupted.
+	Follow bool
+	// Tail is the number of lines to show from the end of the logs, -1 for all.
+	Tail int
+	// Timestamps prefixes every line with its time.
+	Timestamps bool
+}
+
+// consoleLogInterval is how often the console log is polled when following.
+const consoleLogInterval = time.Second
+
+// Logs writes the logs of the given services, or of all services when none
+// are given, to out, in dependency order. The console log is used for OCI
+// instances and the journal for system instances. When following, the first
+// service failing stops following the others.
+func (app *Compose) Logs(ctx context.Context, services []string, opts LogOptions, out io.Writer) error {
+	for _, service := range services {
+		if _, ok := app.Services[service]; !ok {
+			return &types.NotFoundError{Kind: "service", Name: service}
+		}
+	}
+	services = app.OrderFor(services, true)
+
+	mux := ui.NewLogMux(out, services)
+
+	if !opts.Follow {
+		for i, service := range services {
+			w := mux.Writer(service, i)
+			err := app.LogsForService(ctx, service, opts, w)
+			_ = w.Flush()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// following ends without an error when ctx is done, so only the first
+	// error is kept
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(services))
+	for i, service := range services {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := mux.Writer(service, i)
+			errs[i] = app.LogsForService(ctx, service, opts, w)
+			_ = w.Flush()
+			if errs[i] != nil {
+				cancel(errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LogsForService writes the logs of a single service to out. Following the
+// logs ends without an error when ctx is done.
+func (app *Compose) LogsForService(ctx context.Context, service string, opts LogOptions, out io.Writer) error {
+	slog.Debug("Logs", slog.String("instance", service))
+
+	svc, ok := app.Services[service]
+	if !ok {
+		return &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+
+	d, err := app.getInstanceServer(containerName)
+	if err != nil {
+		return err
+	}
+	d = d.UseProject(app.GetProject())
+
+	inst, _, err := d.GetInstance(containerName)
+	if err != nil {
+		return fmt.Errorf("failed loading instance %q: %w", containerName, err)
+	}
+
+	if util.IsTrue(inst.Config["volatile.container.oci"]) {
+		return consoleLogs(ctx, d, containerName, opts, out)
+	}
+
+	if inst.StatusCode != api.Running {
+		slog.Warn("Instance not running, journal unavailable", slog.String("instance", containerName))
+		return nil
+	}
+	return journalLogs(ctx, d, containerName, opts, out)
+}
+
+// consoleLogs writes the console log of an instance. The console log has no
+// timestamps, so the time a line was read is used instead.
+func consoleLogs(ctx context.Context, d Backend, name string, opts LogOptions, out io.Writer) error {
+	content, err := readConsoleLog(d, name)
+	if err != nil {
+		return err
+	}
+
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if opts.Tail >= 0 && len(lines) > opts.Tail {
+		lines = lines[len(lines)-opts.Tail:]
+	}
+	err = writeLogLines(out, lines, opts.Timestamps)
+	if err != nil || !opts.Follow {
+		return err
+	}
+
+	seen := len(content)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(consoleLogInterval):
+		}
+
+		content, err = readConsoleLog(d, name)
+		if err != nil {
+			return err
+		}
+		// the console log starts over when the instance restarts
+		if len(content) < seen {
+			seen = 0
+		}
+		if len(content) == seen {
+			continue
+		}
+
+		err = writeLogLines(out, bytes.SplitAfter(content[seen:], []byte("\n")), opts.Timestamps)
+		if err != nil {
+			return err
+		}
+		seen = len(content)
+	}
+}
+
+func readConsoleLog(d Backend, name string) ([]byte, error) {
+	log, err := d.GetInstanceConsoleLog(name, &incus.InstanceConsoleLogArgs{})
+	if err != nil {
+		return nil, fmt.Errorf("failed reading console log of %q: %w", name, err)
+	}
+	defer func() { _ = log.Close() }()
+
+	return io.ReadAll(log)
+}
+
+func writeLogLines(out io.Writer, lines [][]byte, timestamps bool) error {
+	now := time.Now().Format(time.RFC3339)
+	for _, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		if timestamps {
+			_, err := fmt.Fprintf(out, "%s ", now)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := out.Write(line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// journalLogs writes the journal of an instance by running journalctl in it.
+func journalLogs(ctx context.Context, d Backend, name string, opts LogOptions, out io.Writer) error {
+	command := []string{"journalctl", "--no-pager", "--output", "cat"}
+	if opts.Timestamps {
+		command[3] = "short-iso"
+	}
+	if opts.Tail >= 0 {
+		command = append(command, "--lines", strconv.Itoa(opts.Tail))
+	}
+	if opts.Follow {
+		command = append(command, "--follow")
+	}
+
+	code, err := execInstance(ctx, d, name, command, ExecOptions{
+		Stdin:  bytes.NewReader(nil),
+		Stdout: out,
+		Stderr: out,
+	})
+	if opts.Follow && ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		return fmt.Errorf("journalctl in %q exited with code %d", name, code)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogsFollowStopsOnError(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+
+	// only db is created, web has no instance
+	err := app.Up(context.Background(), []string{"db"}, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	fake.instance("db").Config["volatile.container.oci"] = "true"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Logs(ctx, nil, LogOptions{Follow: true, Tail: -1}, &bytes.Buffer{})
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("following db kept going after web failed")
+	}
+	if err == nil || !strings.Contains(err.Error(), `failed loading instance "web"`) {
+		t.Errorf("error: %v, want the missing web instance", err)
+	}
+}
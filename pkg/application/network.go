@@ -1,6 +1,10 @@
 package application
 
 import (
+	"context"
+	"maps"
+	"net/http"
+
 	api "github.com/lxc/incus/v6/shared/api"
 
 	"log/slog"
@@ -13,7 +17,7 @@ func (c *Compose) DefaultNetworkName() string {
 }
 
 // CreateDefaultNetwork creates the default network for a stack
-func (c *Compose) CreateDefaultNetwork(nettype string) error {
+func (c *Compose) CreateDefaultNetwork(ctx context.Context, nettype string) error {
 
 	// check to see if the Networks map has a default key
 	// if not, return
@@ -21,31 +25,31 @@ func (c *Compose) CreateDefaultNetwork(nettype string) error {
 		return nil
 	}
 
-	var stdinData api.NetworkPut
-	if nettype == "" {
-		nettype = "bridge"
-	}
-
 	// Parse remote
 	resources, err := c.ParseServers(c.DefaultNetworkName())
 	if err != nil {
 		return err
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	resource := resources[0]
 	client := resource.server
 
-	// Create the network
-	network := api.NetworksPost{
-		NetworkPut: stdinData,
+	_, _, err = client.GetNetwork(resource.name)
+	if err == nil {
+		slog.Info("Network found", "name", resource.name)
+		return nil
 	}
 
-	network.Name = resource.name
-	network.Type = nettype
-
-	if network.Config == nil {
-		network.Config = map[string]string{}
+	network := defaultNetworkPost(resource.name, nettype)
+	labels, err := c.stackLabels("", network)
+	if err != nil {
+		return err
 	}
+	maps.Copy(network.Config, labels)
 
 	err = client.CreateNetwork(network)
 	if err != nil {
@@ -57,8 +61,29 @@ func (c *Compose) CreateDefaultNetwork(nettype string) error {
 	return nil
 }
 
+// defaultNetworkPost prepares the network entry for the default network,
+// a bridge unless another type is given
+func defaultNetworkPost(name string, nettype string) api.NetworksPost {
+	var stdinData api.NetworkPut
+	if nettype == "" {
+		nettype = "bridge"
+	}
+
+	network := api.NetworksPost{
+		NetworkPut: stdinData,
+	}
+
+	network.Name = name
+	network.Type = nettype
+
+	if network.Config == nil {
+		network.Config = map[string]string{}
+	}
+	return network
+}
+
 // DestroyDefaultNetwork destroys the default network for a stack
-func (c *Compose) DestroyDefaultNetwork() error {
+func (c *Compose) DestroyDefaultNetwork(ctx context.Context) error {
 	// check to see if the Networks map has a default key
 	// if not, return
 	if _, ok := c.ComposeProject.Networks["default"]; !ok {
@@ -70,11 +95,19 @@ func (c *Compose) DestroyDefaultNetwork() error {
 		return err
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	resource := resources[0]
 
-	// Delete the network
+	// Delete the network, it may be gone already when resuming
 	err = resource.server.DeleteNetwork(resource.name)
 	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			slog.Info("Network not found", "name", resource.name)
+			return nil
+		}
 		return err
 	}
 	slog.Info("Network deleted", "name", resource.name)
@@ -0,0 +1,97 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	incus "github.com/lxc/incus/v6/client"
+	"github.com/lxc/incus/v6/shared/cancel"
+)
+
+// operationContext bounds ctx by OperationTimeout, if one is set.
+func (app *Compose) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if app.OperationTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, app.OperationTimeout)
+}
+
+// wait waits for an operation to complete within OperationTimeout. The
+// operation is cancelled on the server when ctx is done or the timeout
+// expires.
+func (app *Compose) wait(ctx context.Context, op incus.Operation) error {
+	ctx, cancel := app.operationContext(ctx)
+	defer cancel()
+
+	return waitOperation(ctx, op)
+}
+
+// waitOperation waits for an operation to complete, cancelling it on the
+// server when ctx is done.
+func waitOperation(ctx context.Context, op incus.Operation) error {
+	err := op.WaitContext(ctx)
+	if ctx.Err() == nil {
+		return err
+	}
+
+	// not every operation can be cancelled, those run to completion
+	cancelErr := op.Cancel()
+	if cancelErr != nil {
+		slog.Debug("Operation not cancelled", slog.String("operation", op.Get().Description), slog.String("error", cancelErr.Error()))
+	}
+	return fmt.Errorf("%s: %w", op.Get().Description, context.Cause(ctx))
+}
+
+// waitRemote waits for an operation that may span several servers, such as
+// creating an instance from a remote image, within OperationTimeout.
+func (app *Compose) waitRemote(ctx context.Context, op incus.RemoteOperation) error {
+	ctx, cancel := app.operationContext(ctx)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- op.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	cancelErr := op.CancelTarget()
+	if cancelErr != nil {
+		slog.Debug("Operation not cancelled", slog.String("error", cancelErr.Error()))
+	}
+	return context.Cause(ctx)
+}
+
+// downloadCanceller returns a canceller that interrupts a download once ctx
+// is done. The returned function must be called when the download finished.
+func downloadCanceller(ctx context.Context) (*cancel.HTTPRequestCanceller, func()) {
+	canceller := cancel.NewHTTPRequestCanceller()
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+		}
+
+		// the download request may not have been registered yet
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for canceller.Cancel() != nil {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return canceller, func() { close(done) }
+}
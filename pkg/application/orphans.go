@@ -0,0 +1,141 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// orphans are the resources tagged with the stack that the compose file no
+// longer declares
+type orphans struct {
+	server    Backend
+	instances []api.Instance
+	volumes   []orphanVolume
+	networks  []api.Network
+}
+
+type orphanVolume struct {
+	api.StorageVolume
+	pool string
+}
+
+// findOrphans lists the instances, custom volumes and networks in the
+// project of the stack that are tagged with its name but aren't declared in
+// the compose file anymore.
+func (app *Compose) findOrphans() (*orphans, error) {
+	resources, err := app.ParseServers(app.Name)
+	if err != nil {
+		return nil, err
+	}
+	d := resources[0].server.UseProject(app.GetProject())
+	found := &orphans{server: d}
+
+	instances := map[string]bool{}
+	volumes := map[string]bool{}
+	for _, svc := range app.Services {
+		containerName := svc.GetContainerName()
+		_, name, err := app.conf.ParseRemote(containerName)
+		if err != nil {
+			return nil, err
+		}
+		instances[name] = true
+		for volName, vol := range svc.Volumes {
+			volumes[vol.Pool+"/"+vol.CreateName(app.Name, containerName, volName)] = true
+		}
+	}
+
+	all, err := d.GetInstances(api.InstanceTypeAny)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing instances: %w", err)
+	}
+	for _, inst := range all {
+		if inst.Config[stackKey] == app.Name && inst.Config[runKey] != "true" && !instances[inst.Name] {
+			found.instances = append(found.instances, inst)
+		}
+	}
+
+	pools, err := d.GetStoragePoolNames()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing storage pools: %w", err)
+	}
+	for _, pool := range pools {
+		vols, err := d.GetStoragePoolVolumes(pool)
+		if err != nil {
+			return nil, fmt.Errorf("failed listing volumes of pool %q: %w", pool, err)
+		}
+		for _, vol := range vols {
+			if vol.Type != "custom" || vol.Config[stackKey] != app.Name || volumes[pool+"/"+vol.Name] {
+				continue
+			}
+			found.volumes = append(found.volumes, orphanVolume{StorageVolume: vol, pool: pool})
+		}
+	}
+
+	networks, err := d.GetNetworks()
+	if err != nil {
+		return nil, fmt.Errorf("failed listing networks: %w", err)
+	}
+	_, defaultDeclared := app.ComposeProject.Networks["default"]
+	for _, network := range networks {
+		if network.Config[stackKey] != app.Name {
+			continue
+		}
+		if defaultDeclared && network.Name == resources[0].name {
+			continue
+		}
+		found.networks = append(found.networks, network)
+	}
+
+	return found, nil
+}
+
+// RemoveOrphans deletes the instances and networks tagged with the stack
+// that aren't declared in the compose file anymore. Orphaned custom volumes
+// are only deleted when volumes is set.
+func (app *Compose) RemoveOrphans(ctx context.Context, volumes bool) error {
+	found, err := app.findOrphans()
+	if err != nil {
+		return err
+	}
+
+	if !volumes {
+		for _, vol := range found.volumes {
+			slog.Warn("Orphan volume not deleted", slog.String("volume", vol.Name), slog.String("pool", vol.pool))
+		}
+	}
+	return app.applyActions(ctx, app.orphanActions(found, volumes))
+}
+
+// orphanActions deletes the orphans of the stack, orphaned custom volumes
+// only when volumes is set.
+func (app *Compose) orphanActions(found *orphans, volumes bool) []Action {
+	actions := []Action{}
+	for _, inst := range found.instances {
+		details := map[string]string{"reason": "orphan"}
+		if inst.StatusCode == api.Running {
+			details["force"] = "true"
+		}
+		actions = append(actions, newAction("delete", "instance", inst.Config[serviceKey], inst.Name, details, func(ctx context.Context) error {
+			slog.Info("Removing orphan", slog.String("instance", inst.Name), slog.String("service", inst.Config[serviceKey]))
+			return app.removeInstance(ctx, inst.Name, true)
+		}))
+	}
+	if volumes {
+		for _, vol := range found.volumes {
+			actions = append(actions, newAction("delete", "volume", vol.Config[serviceKey], vol.Name, map[string]string{"reason": "orphan", "pool": vol.pool}, func(ctx context.Context) error {
+				slog.Info("Removing orphan", slog.String("volume", vol.Name), slog.String("pool", vol.pool))
+				return found.server.DeleteStoragePoolVolume(vol.pool, vol.Type, vol.Name)
+			}))
+		}
+	}
+	for _, network := range found.networks {
+		actions = append(actions, newAction("delete", "network", "", network.Name, map[string]string{"reason": "orphan"}, func(ctx context.Context) error {
+			slog.Info("Removing orphan", slog.String("network", network.Name))
+			return found.server.DeleteNetwork(network.Name)
+		}))
+	}
+	return actions
+}
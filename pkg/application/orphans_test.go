@@ -0,0 +1,102 @@
+package application
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+func TestRemoveOrphans(t *testing.T) {
+	ctx := context.Background()
+	app, fake := newTestApp(t, testCompose)
+	err := app.Up(ctx, nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	// the one-off instance is left stopped
+	_, err = app.RunContainerForService(ctx, "db", []string{"true"}, false, ExecOptions{})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var run string
+	for _, call := range fake.called("CreateInstance") {
+		if name := strings.TrimPrefix(call, "CreateInstance "); strings.HasPrefix(name, "db-run-") {
+			run = name
+		}
+	}
+	if run == "" {
+		t.Fatalf("run instance not created: %v", fake.calls)
+	}
+
+	// web isn't declared anymore
+	app, _ = newTestApp(t, `
+name: shop
+services:
+  db:
+    image: alpine
+    volumes:
+      - data:/var/lib/db
+volumes:
+  data: {}
+`)
+	app.connect = fake.connect
+	found, err := app.findOrphans()
+	if err != nil {
+		t.Fatalf("find orphans: %v", err)
+	}
+	names := []string{}
+	for _, inst := range found.instances {
+		names = append(names, inst.Name)
+	}
+	if !slices.Equal(names, []string{"web"}) {
+		t.Errorf("orphans: %v, want [web]", names)
+	}
+
+	err = app.Up(ctx, nil, RecreateNever, true, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if got := fake.called("DeleteInstance"); !slices.Equal(got, []string{"DeleteInstance web"}) {
+		t.Errorf("instances deleted: %v", got)
+	}
+	if fake.instance(run) == nil {
+		t.Errorf("run instance %s removed as an orphan", run)
+	}
+}
+
+func TestGroupStacks(t *testing.T) {
+	instance := func(name, service string, status api.StatusCode, run bool) api.Instance {
+		config := map[string]string{
+			labelKey:     "true",
+			directoryKey: "/srv/shop",
+			stackKey:     "shop",
+			serviceKey:   service,
+		}
+		if run {
+			config[runKey] = "true"
+		}
+		return api.Instance{
+			Name:        name,
+			Project:     "default",
+			InstancePut: api.InstancePut{Config: config},
+			StatusCode:  status,
+		}
+	}
+	instances := []api.Instance{
+		instance("db", "db", api.Stopped, false),
+		instance("web", "web", api.Running, false),
+		instance("db-run-abcdef", "db", api.Running, true),
+		{Name: "other", InstancePut: api.InstancePut{Config: map[string]string{}}},
+	}
+
+	got := groupStacks(instances)
+	want := []Stack{{Name: "shop", Directory: "/srv/shop", Running: 1, Services: 2, Projects: []string{"default"}}}
+	if len(got) != 1 || got[0].Name != want[0].Name || got[0].Directory != want[0].Directory ||
+		got[0].Running != want[0].Running || got[0].Services != want[0].Services ||
+		!slices.Equal(got[0].Projects, want[0].Projects) {
+		t.Errorf("stacks: %+v, want %+v", got, want)
+	}
+}
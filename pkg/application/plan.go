@@ -0,0 +1,821 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	compose "github.com/compose-spec/compose-go/v2/types"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// Action is a change a command would make to an Incus object.
+type Action struct {
+	// Verb is what happens to the object: create, update, recreate, delete,
+	// attach, start, stop, restart, rebuild, snapshot or export.
+	Verb string `json:"action" yaml:"action"`
+	// Kind is the type of the object: network, instance, volume, device or
+	// secret.
+	Kind    string            `json:"kind" yaml:"kind"`
+	Service string            `json:"service,omitempty" yaml:"service,omitempty"`
+	Name    string            `json:"name" yaml:"name"`
+	Details map[string]string `json:"details,omitempty" yaml:"details,omitempty"`
+	// Duration is how long applying the action took, it is zero in plans.
+	Duration time.Duration `json:"duration,omitempty" yaml:"duration,omitempty"`
+
+	// apply makes the change, it is nil for actions read from a journal
+	apply func(ctx context.Context) error
+}
+
+// Plan lists the actions of a command in the order they would be applied.
+// Plans are computed from the compose file and the live state of the stack
+// without changing anything.
+type Plan struct {
+	Actions []Action `json:"actions" yaml:"actions"`
+}
+
+func newAction(verb, kind, service, name string, details map[string]string, apply func(ctx context.Context) error) Action {
+	return Action{
+		Verb:    verb,
+		Kind:    kind,
+		Service: service,
+		Name:    name,
+		Details: details,
+		apply:   apply,
+	}
+}
+
+// applyActions applies actions in order and tells the hooks about each of
+// them. Commands compute their actions with the same functions as their
+// plans, so a plan shows what the command does.
+func (app *Compose) applyActions(ctx context.Context, actions []Action) error {
+	for _, a := range actions {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		start := time.Now()
+		submitted := false
+		err := a.apply(withSubmit(ctx, func() { submitted = true }))
+		if err != nil {
+			if submitted {
+				app.submitted(a)
+			}
+			return err
+		}
+		app.applied(start, a)
+	}
+	return nil
+}
+
+// PlanUp returns the plan for Up.
+func (app *Compose) PlanUp(ctx context.Context, services []string, policy RecreatePolicy, removeOrphans bool) (*Plan, error) {
+	plan := &Plan{}
+	if removeOrphans {
+		found, err := app.findOrphans()
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, app.orphanActions(found, false)...)
+	}
+
+	create, err := app.PlanCreate(ctx, services, policy)
+	if err != nil {
+		return nil, err
+	}
+	plan.Actions = append(plan.Actions, create.Actions...)
+
+	// created and recreated instances are stopped
+	created := map[string]bool{}
+	for _, a := range plan.Actions {
+		if a.Kind == "instance" && (a.Verb == "create" || a.Verb == "recreate") {
+			created[a.Service] = true
+		}
+	}
+
+	for _, service := range app.levelOrder(services, false) {
+		svc := app.Services[service]
+		containerName := svc.GetContainerName()
+		_, inst, err := app.liveInstance(containerName)
+		if err != nil {
+			return nil, err
+		}
+		running := inst != nil && inst.StatusCode == api.Running && !created[service]
+		plan.Actions = append(plan.Actions, app.startActions(service, containerName, running)...)
+	}
+	return plan, nil
+}
+
+// PlanCreate returns the plan for Create.
+func (app *Compose) PlanCreate(ctx context.Context, services []string, policy RecreatePolicy) (*Plan, error) {
+	network, err := app.createNetworkActions()
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Actions: network}
+
+	for _, service := range app.levelOrder(services, false) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		actions, err := app.createActions(service, len(network) > 0, policy)
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+	return plan, nil
+}
+
+// createNetworkActions creates the default network when the stack declares
+// it and it doesn't exist.
+func (app *Compose) createNetworkActions() ([]Action, error) {
+	declared, exists, err := app.liveDefaultNetwork()
+	if err != nil {
+		return nil, err
+	}
+	if !declared || exists {
+		return nil, nil
+	}
+
+	network := defaultNetworkPost(app.DefaultNetworkName(), "")
+	return []Action{newAction("create", "network", "", network.Name, map[string]string{"type": network.Type}, func(ctx context.Context) error {
+		return app.CreateDefaultNetwork(ctx, "")
+	})}, nil
+}
+
+// createActions creates the volumes, secrets and instance of a service that
+// don't exist yet, brings the existing ones in line with the compose file
+// and attaches the missing devices. newNetwork is set when the default
+// network would be created first.
+func (app *Compose) createActions(service string, newNetwork bool, policy RecreatePolicy) ([]Action, error) {
+	sc, err := app.ComposeProject.GetService(service)
+	if err != nil {
+		return nil, err
+	}
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+
+	d, inst, err := app.liveInstance(svc.GetContainerName())
+	if err != nil {
+		return nil, err
+	}
+	networks := liveNetworks(d)
+	if newNetwork {
+		networks = plannedNetworks(d, app.DefaultNetworkName())
+	}
+
+	// volumes and secrets first, an existing instance may get them attached
+	// when it is updated
+	actions, err := app.volumeActions(service)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := app.secretActions(service)
+	if err != nil {
+		return nil, err
+	}
+	actions = append(actions, secrets...)
+
+	instance, devices, err := app.instanceActions(d, sc, inst, networks, policy)
+	if err != nil {
+		return nil, err
+	}
+	actions = append(actions, instance...)
+
+	attach, err := app.deviceActions(service, devices)
+	if err != nil {
+		return nil, err
+	}
+	return append(actions, attach...), nil
+}
+
+// volumeActions creates the custom volumes of a service that don't exist
+// yet and updates the snapshot settings and labels of the others.
+func (app *Compose) volumeActions(service string) ([]Action, error) {
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+
+	actions := []Action{}
+	for _, volName := range slices.Sorted(maps.Keys(svc.Volumes)) {
+		vol := svc.Volumes[volName]
+		name := vol.CreateName(app.Name, containerName, volName)
+		newvol, err := app.volumePost(service, name, *vol)
+		if err != nil {
+			return nil, err
+		}
+
+		existing, _ := app.showVolume(containerName, name, *vol)
+		if existing != nil {
+			drifts, config := diffConfig(newvol.Config, existing.Config, managedVolumeKey)
+			if len(drifts) == 0 {
+				continue
+			}
+			actions = append(actions, newAction("update", "volume", service, name, map[string]string{"changes": joinDrift(drifts)}, func(ctx context.Context) error {
+				return app.updateVolume(ctx, existing, *vol, config)
+			}))
+			continue
+		}
+
+		details := map[string]string{"pool": vol.Pool}
+		for k, v := range newvol.Config {
+			if !strings.HasPrefix(k, labelKey) {
+				details[k] = v
+			}
+		}
+		actions = append(actions, newAction("create", "volume", service, name, details, func(ctx context.Context) error {
+			return app.createVolume(ctx, newvol, *vol)
+		}))
+	}
+	return actions, nil
+}
+
+// instanceActions creates the instance of a service when inst, the live
+// instance, is nil, or brings it in line with the compose file according to
+// the policy. It also returns the devices the instance has afterwards.
+func (app *Compose) instanceActions(d Backend, sc compose.ServiceConfig, inst *api.Instance, networks networkLookup, policy RecreatePolicy) ([]Action, map[string]map[string]string, error) {
+	svc, ok := app.Services[sc.Name]
+	if !ok {
+		return nil, nil, &types.NotFoundError{Kind: "service", Name: sc.Name}
+	}
+	containerName := svc.GetContainerName()
+	remote, _, err := app.conf.ParseRemote(containerName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if inst == nil {
+		instancePost, err := app.instanceForService(d, sc, networks)
+		if err != nil {
+			return nil, nil, err
+		}
+		details := map[string]string{
+			"image":    sc.Image,
+			"profiles": strings.Join(instancePost.Profiles, ","),
+			"devices":  strings.Join(slices.Sorted(maps.Keys(instancePost.Devices)), ","),
+		}
+		return []Action{newAction("create", "instance", sc.Name, containerName, details, func(ctx context.Context) error {
+			return app.createInstance(ctx, d, remote, sc.Image, instancePost)
+		})}, instancePost.Devices, nil
+	}
+
+	desired, err := app.desiredInstance(d, sc, networks)
+	if err != nil {
+		return nil, nil, err
+	}
+	drift := diffInstance(desired.InstancePut, inst)
+
+	// don't recreate twice when resuming a run
+	if policy == RecreateAlways && app.recreated[sc.Name] {
+		policy = RecreateChanged
+	}
+
+	switch {
+	case policy == RecreateAlways || (policy == RecreateChanged && len(drift.recreate) > 0):
+		reason := joinDrift(drift.recreate)
+		if policy == RecreateAlways {
+			reason = "forced"
+		}
+		// the new instance has none of the attached devices
+		return []Action{newAction("recreate", "instance", sc.Name, containerName, map[string]string{"reason": reason}, func(ctx context.Context) error {
+			slog.Info("Recreating", slog.String("instance", containerName), slog.String("reason", reason))
+			return app.recreateInstance(ctx, d, remote, sc)
+		})}, nil, nil
+	case len(drift.changes) == 0 && len(drift.recreate) == 0:
+		return nil, drift.put.Devices, nil
+	}
+
+	details := map[string]string{}
+	if len(drift.changes) > 0 {
+		details["changes"] = joinDrift(drift.changes)
+	}
+	if len(drift.recreate) > 0 {
+		details["skipped"] = joinDrift(drift.recreate)
+	}
+	return []Action{newAction("update", "instance", sc.Name, containerName, details, func(ctx context.Context) error {
+		if len(drift.recreate) > 0 {
+			slog.Warn("Changes need the instance to be recreated, skipped", slog.String("instance", containerName), slog.String("reason", joinDrift(drift.recreate)))
+		}
+		if len(drift.changes) == 0 {
+			return nil
+		}
+		slog.Info("Updating", slog.String("instance", containerName), slog.String("changes", joinDrift(drift.changes)))
+		return app.reconcileInstance(ctx, d, containerName, desired.InstancePut)
+	})}, drift.put.Devices, nil
+}
+
+// secretActions copies the secrets files of a service to its local secrets
+// directory.
+func (app *Compose) secretActions(service string) ([]Action, error) {
+	files, err := app.secretFilesForService(service)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []Action{}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		details := map[string]string{
+			"source": files[name],
+			"path":   filepath.Join(secretsDir(service), name),
+		}
+		actions = append(actions, newAction("create", "secret", service, name, details, func(ctx context.Context) error {
+			return writeSecret(service, name, files[name])
+		}))
+	}
+	return actions, nil
+}
+
+// deviceActions attaches the custom volumes, bind mounts and secrets of a
+// service to its instance, except the devices it already has.
+func (app *Compose) deviceActions(service string, devices map[string]map[string]string) ([]Action, error) {
+	svc := app.Services[service]
+	containerName := svc.GetContainerName()
+	attached, err := app.serviceDevices(service)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []Action{}
+	for _, name := range slices.Sorted(maps.Keys(attached)) {
+		if _, ok := devices[name]; ok {
+			continue
+		}
+		dev := attached[name]
+		actions = append(actions, newAction("attach", "device", service, containerName+"/"+name, dev, func(ctx context.Context) error {
+			return app.attachDevice(ctx, containerName, name, dev)
+		}))
+	}
+	return actions, nil
+}
+
+// PlanUpdate returns the plan for Update.
+func (app *Compose) PlanUpdate(ctx context.Context, services []string) (*Plan, error) {
+	plan := &Plan{}
+
+	for _, service := range app.levelOrder(services, false) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		actions, err := app.updateActions(service)
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+	return plan, nil
+}
+
+// updateActions rebuilds the instance of a service when its image points to
+// a newer fingerprint or OCI digest than the instance was built from.
+func (app *Compose) updateActions(service string) ([]Action, error) {
+	sc, err := app.ComposeProject.GetService(service)
+	if err != nil {
+		return nil, err
+	}
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+
+	remote, _, err := app.conf.ParseRemote(containerName)
+	if err != nil {
+		return nil, err
+	}
+	d, inst, err := app.liveInstance(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if inst == nil {
+		slog.Info("Instance not found", slog.String("instance", containerName))
+		return nil, nil
+	}
+
+	var source api.InstanceSource
+	imgServer, imgInfo, err := app.resolveImage(d, remote, sc.Image, &source)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := imageFingerprint(imgServer, imgInfo, source)
+	if err != nil {
+		return nil, err
+	}
+	current := inst.Config["volatile.base_image"]
+	if current == latest {
+		slog.Info("Instance up to date", slog.String("instance", containerName), slog.String("image", sc.Image))
+		return nil, nil
+	}
+
+	running := inst.StatusCode == api.Running
+	details := map[string]string{
+		"image":   sc.Image,
+		"current": current,
+		"latest":  latest,
+		"running": strconv.FormatBool(running),
+	}
+	return []Action{newAction("rebuild", "instance", service, containerName, details, func(ctx context.Context) error {
+		return app.rebuildInstance(ctx, d, service, imgServer, imgInfo, source, running)
+	})}, nil
+}
+
+// PlanStart returns the plan for Start.
+func (app *Compose) PlanStart(ctx context.Context, services []string) (*Plan, error) {
+	plan := &Plan{}
+
+	for _, service := range app.levelOrder(services, false) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		svc := app.Services[service]
+		containerName := svc.GetContainerName()
+		_, inst, err := app.liveInstance(containerName)
+		if err != nil {
+			return nil, err
+		}
+		if inst == nil {
+			return nil, &types.NotFoundError{Kind: "instance", Name: containerName}
+		}
+		plan.Actions = append(plan.Actions, app.startActions(service, containerName, inst.StatusCode == api.Running)...)
+	}
+	return plan, nil
+}
+
+// startActions starts the instance of a service unless it is running.
+func (app *Compose) startActions(service, containerName string, running bool) []Action {
+	if running {
+		return nil
+	}
+	return []Action{newAction("start", "instance", service, containerName, nil, func(ctx context.Context) error {
+		return app.updateInstanceState(ctx, containerName, "start", -1, false, false)
+	})}
+}
+
+// PlanRestart returns the plan for Restart.
+func (app *Compose) PlanRestart(ctx context.Context, services []string) (*Plan, error) {
+	plan := &Plan{}
+
+	for _, service := range app.levelOrder(services, false) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		actions, err := app.restartActions(service)
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+	return plan, nil
+}
+
+// restartActions restarts the instance of a service.
+func (app *Compose) restartActions(service string) ([]Action, error) {
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+	_, inst, err := app.liveInstance(containerName)
+	if err != nil {
+		return nil, err
+	}
+	if inst == nil {
+		return nil, &types.NotFoundError{Kind: "instance", Name: containerName}
+	}
+	return []Action{newAction("restart", "instance", service, containerName, nil, func(ctx context.Context) error {
+		return app.updateInstanceState(ctx, containerName, "restart", -1, false, false)
+	})}, nil
+}
+
+// PlanStop returns the plan for Stop.
+func (app *Compose) PlanStop(ctx context.Context, services []string, stateful, force bool, timeout int) (*Plan, error) {
+	plan := &Plan{}
+
+	for _, service := range app.levelOrder(services, true) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		svc := app.Services[service]
+		containerName := svc.GetContainerName()
+		_, inst, err := app.liveInstance(containerName)
+		if err != nil {
+			return nil, err
+		}
+		running := inst != nil && inst.StatusCode == api.Running
+		plan.Actions = append(plan.Actions, app.stopActions(service, containerName, running, stateful, force, timeout)...)
+	}
+	return plan, nil
+}
+
+// stopActions stops the instance of a service when it is running.
+func (app *Compose) stopActions(service, containerName string, running, stateful, force bool, timeout int) []Action {
+	if !running {
+		return nil
+	}
+	return []Action{newAction("stop", "instance", service, containerName, stopDetails(stateful, force, timeout), func(ctx context.Context) error {
+		return app.updateInstanceState(ctx, containerName, "stop", timeout, force, stateful)
+	})}
+}
+
+// PlanDown returns the plan for Down.
+func (app *Compose) PlanDown(ctx context.Context, force, volumes, removeOrphans bool, timeout int) (*Plan, error) {
+	plan := &Plan{}
+	if removeOrphans {
+		found, err := app.findOrphans()
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, app.orphanActions(found, volumes)...)
+	}
+
+	down, err := app.planRemove(ctx, nil, force, force, true, volumes, timeout)
+	if err != nil {
+		return nil, err
+	}
+	plan.Actions = append(plan.Actions, down.Actions...)
+	return plan, nil
+}
+
+// PlanRemove returns the plan for Remove.
+func (app *Compose) PlanRemove(ctx context.Context, services []string, timeout int, force, stop, volumes bool) (*Plan, error) {
+	return app.planRemove(ctx, services, true, force, stop, volumes, timeout)
+}
+
+// planRemove plans removing the services and, once all of them are
+// removed, the default network.
+func (app *Compose) planRemove(ctx context.Context, services []string, forceStop, force, stop, volumes bool, timeout int) (*Plan, error) {
+	plan := &Plan{}
+
+	for _, service := range app.levelOrder(services, true) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		actions, err := app.removeActions(service, forceStop, force, stop, volumes, timeout)
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+
+	network, err := app.destroyNetworkActions(services)
+	if err != nil {
+		return nil, err
+	}
+	plan.Actions = append(plan.Actions, network...)
+	return plan, nil
+}
+
+// removeActions stops (when stop is set) and deletes the instance of a
+// service, and deletes its volumes when volumes is set. A running instance
+// can only be deleted without stopping it first when force is set.
+func (app *Compose) removeActions(service string, forceStop, force, stop, volumes bool, timeout int) ([]Action, error) {
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+	_, inst, err := app.liveInstance(containerName)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := []Action{}
+	if inst != nil {
+		running := inst.StatusCode == api.Running
+		if stop {
+			actions = append(actions, app.stopActions(service, containerName, running, false, forceStop, timeout)...)
+		}
+		if running && !stop && !force {
+			return nil, fmt.Errorf("%w: instance %s is running, stop it first or use --force", types.ErrConflict, containerName)
+		}
+		actions = append(actions, newAction("delete", "instance", service, containerName, nil, func(ctx context.Context) error {
+			return app.removeInstance(ctx, containerName, force)
+		}))
+	}
+	if !volumes {
+		return actions, nil
+	}
+
+	for _, volName := range slices.Sorted(maps.Keys(svc.Volumes)) {
+		vol := svc.Volumes[volName]
+		name := vol.CreateName(app.Name, containerName, volName)
+		existing, _ := app.showVolume(containerName, name, *vol)
+		if existing == nil {
+			continue
+		}
+		actions = append(actions, newAction("delete", "volume", service, name, map[string]string{"pool": vol.Pool}, func(ctx context.Context) error {
+			return app.deleteVolume(name, *vol)
+		}))
+	}
+	return actions, nil
+}
+
+// destroyNetworkActions deletes the default network when it exists and all
+// services are removed, otherwise it is still in use.
+func (app *Compose) destroyNetworkActions(services []string) ([]Action, error) {
+	if !app.allServices(services) {
+		return nil, nil
+	}
+	_, exists, err := app.liveDefaultNetwork()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	return []Action{newAction("delete", "network", "", app.DefaultNetworkName(), nil, app.DestroyDefaultNetwork)}, nil
+}
+
+// PlanSnapshot returns the plan for Snapshot.
+func (app *Compose) PlanSnapshot(ctx context.Context, noexpiry, stateful, volumes bool) (*Plan, error) {
+	plan := &Plan{}
+
+	for _, service := range app.levelOrder(nil, true) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		actions, err := app.snapshotActions(service, noexpiry, stateful, volumes)
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+	return plan, nil
+}
+
+// snapshotActions snapshots the instance of a service and, when volumes is
+// set, its custom volumes. Snapshots expire after a week unless noexpiry is
+// set.
+func (app *Compose) snapshotActions(service string, noexpiry, stateful, volumes bool) ([]Action, error) {
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+
+	expiration := time.Now().Add(time.Hour * 24 * 7)
+	details := map[string]string{
+		"stateful": strconv.FormatBool(stateful),
+		"expiry":   "7d",
+	}
+	if noexpiry {
+		details["expiry"] = "none"
+	}
+
+	snapshot := snapshotName(containerName)
+	actions := []Action{newAction("snapshot", "instance", service, containerName+"/"+snapshot, details, func(ctx context.Context) error {
+		return app.createSnapshot(ctx, containerName, snapshot, stateful, noexpiry, expiration)
+	})}
+	if !volumes {
+		return actions, nil
+	}
+
+	for _, volName := range slices.Sorted(maps.Keys(svc.Volumes)) {
+		vol := svc.Volumes[volName]
+		name := vol.CreateName(app.Name, containerName, volName)
+		snapshot := snapshotName(name)
+		volDetails := maps.Clone(details)
+		volDetails["pool"] = vol.Pool
+		actions = append(actions, newAction("snapshot", "volume", service, name+"/"+snapshot, volDetails, func(ctx context.Context) error {
+			return app.volumeSnapshot(ctx, vol.Pool, name, snapshot, stateful, noexpiry, expiration)
+		}))
+	}
+	return actions, nil
+}
+
+// PlanExport returns the plan for Export.
+func (app *Compose) PlanExport(ctx context.Context, volumes bool, customVolumesOnly bool) (*Plan, error) {
+	plan := &Plan{}
+
+	for _, service := range app.levelOrder(nil, true) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		actions, err := app.exportActions(service, volumes, customVolumesOnly)
+		if err != nil {
+			return nil, err
+		}
+		plan.Actions = append(plan.Actions, actions...)
+	}
+	return plan, nil
+}
+
+// exportActions exports the instance of a service to a file in ExportPath,
+// with its volumes when volumes is set. With customVolumesOnly its custom
+// volumes are exported instead, each to its own file.
+func (app *Compose) exportActions(service string, volumes bool, customVolumesOnly bool) ([]Action, error) {
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+	containerName := svc.GetContainerName()
+
+	if !customVolumesOnly {
+		file := filepath.Join(app.ExportPath, exportName(containerName))
+		details := map[string]string{
+			"file":    file,
+			"volumes": strconv.FormatBool(volumes),
+		}
+		return []Action{newAction("export", "instance", service, containerName, details, func(ctx context.Context) error {
+			return app.instanceExport(ctx, containerName, file, !volumes)
+		})}, nil
+	}
+
+	actions := []Action{}
+	for _, volName := range slices.Sorted(maps.Keys(svc.Volumes)) {
+		vol := svc.Volumes[volName]
+		name := vol.CreateName(app.Name, containerName, volName)
+		file := filepath.Join(app.ExportPath, exportName(name))
+		details := map[string]string{
+			"file": file,
+			"pool": vol.Pool,
+		}
+		actions = append(actions, newAction("export", "volume", service, name, details, func(ctx context.Context) error {
+			return app.volumeExport(ctx, vol.Pool, name, file)
+		}))
+	}
+	return actions, nil
+}
+
+// levelOrder returns the services in the order runLevels operates on them
+func (app *Compose) levelOrder(services []string, reverse bool) []string {
+	order := []string{}
+	for _, level := range app.Levels(services, reverse) {
+		order = append(order, level...)
+	}
+	return order
+}
+
+// liveInstance returns the instance server for an instance and the instance,
+// which is nil when it doesn't exist.
+func (app *Compose) liveInstance(containerName string) (Backend, *api.Instance, error) {
+	d, err := app.getInstanceServer(containerName)
+	if err != nil {
+		return nil, nil, err
+	}
+	d = d.UseProject(app.GetProject())
+
+	inst, _, err := d.GetInstance(containerName)
+	if err != nil {
+		if api.StatusErrorCheck(err, http.StatusNotFound) {
+			return d, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed loading instance %q: %w", containerName, err)
+	}
+	return d, inst, nil
+}
+
+// liveDefaultNetwork reports whether the stack declares a default network
+// and whether that network exists.
+func (app *Compose) liveDefaultNetwork() (bool, bool, error) {
+	if _, ok := app.ComposeProject.Networks["default"]; !ok {
+		return false, false, nil
+	}
+
+	resources, err := app.ParseServers(app.DefaultNetworkName())
+	if err != nil {
+		return true, false, err
+	}
+	resource := resources[0]
+
+	_, _, err = resource.server.GetNetwork(resource.name)
+	return true, err == nil, nil
+}
+
+// plannedNetworks looks networks up on the server, treating the default
+// network that is about to be created as an existing managed bridge.
+func plannedNetworks(d Backend, defaultNetwork string) networkLookup {
+	live := liveNetworks(d)
+	return func(name string) (*api.Network, error) {
+		if name != defaultNetwork {
+			return live(name)
+		}
+		network := defaultNetworkPost(name, "")
+		return &api.Network{Name: network.Name, Type: network.Type, Managed: true}, nil
+	}
+}
+
+func stopDetails(stateful, force bool, timeout int) map[string]string {
+	details := map[string]string{}
+	if stateful {
+		details["stateful"] = "true"
+	}
+	if force {
+		details["force"] = "true"
+	}
+	if timeout >= 0 {
+		details["timeout"] = strconv.Itoa(timeout)
+	}
+	return details
+}
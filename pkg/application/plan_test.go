@@ -0,0 +1,259 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+)
+
+// planCompose names the instance of db, so its volume is named after the
+// instance rather than the service
+const planCompose = `
+name: shop
+services:
+  db:
+    image: alpine
+    container_name: database
+    volumes:
+      - data:/var/lib/db
+  web:
+    image: alpine
+    depends_on:
+      - db
+volumes:
+  data: {}
+`
+
+// recorder is a hook keeping the applied actions
+type recorder struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+func (r *recorder) Applied(action Action) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, action)
+}
+
+// summary lists the verb, kind and name of actions. The names of snapshots
+// carry the time they're taken, only the snapshotted object is kept.
+func summary(actions []Action) []string {
+	s := []string{}
+	for _, a := range actions {
+		name := a.Name
+		if a.Verb == "snapshot" {
+			name, _, _ = strings.Cut(name, "/")
+		}
+		s = append(s, a.Verb+" "+a.Kind+" "+name)
+	}
+	return s
+}
+
+func TestPlanMatchesApply(t *testing.T) {
+	up := func(ctx context.Context, app *Compose) error {
+		return app.Up(ctx, nil, RecreateNever, false, false)
+	}
+	tests := []struct {
+		name  string
+		setup func(ctx context.Context, app *Compose) error
+		plan  func(ctx context.Context, app *Compose) (*Plan, error)
+		apply func(ctx context.Context, app *Compose) error
+		want  []string
+	}{
+		{
+			name: "up",
+			plan: func(ctx context.Context, app *Compose) (*Plan, error) {
+				return app.PlanUp(ctx, nil, RecreateNever, false)
+			},
+			apply: up,
+			want: []string{
+				"create network shop",
+				"create volume shop-database-data",
+				"create instance database",
+				"attach device database/shop-database-data",
+				"create instance web",
+				"start instance database",
+				"start instance web",
+			},
+		},
+		{
+			name:  "up to date",
+			setup: up,
+			plan: func(ctx context.Context, app *Compose) (*Plan, error) {
+				return app.PlanUp(ctx, nil, RecreateNever, false)
+			},
+			apply: up,
+			want:  []string{},
+		},
+		{
+			name:  "recreate",
+			setup: up,
+			plan: func(ctx context.Context, app *Compose) (*Plan, error) {
+				return app.PlanUp(ctx, nil, RecreateAlways, false)
+			},
+			apply: func(ctx context.Context, app *Compose) error {
+				return app.Up(ctx, nil, RecreateAlways, false, false)
+			},
+			want: []string{
+				"recreate instance database",
+				"attach device database/shop-database-data",
+				"recreate instance web",
+				"start instance database",
+				"start instance web",
+			},
+		},
+		{
+			name:  "stop",
+			setup: up,
+			plan: func(ctx context.Context, app *Compose) (*Plan, error) {
+				return app.PlanStop(ctx, nil, false, false, -1)
+			},
+			apply: func(ctx context.Context, app *Compose) error {
+				return app.Stop(ctx, nil, false, false, -1)
+			},
+			want: []string{"stop instance web", "stop instance database"},
+		},
+		{
+			name:  "down",
+			setup: up,
+			plan: func(ctx context.Context, app *Compose) (*Plan, error) {
+				return app.PlanDown(ctx, false, true, false, -1)
+			},
+			apply: func(ctx context.Context, app *Compose) error {
+				return app.Down(ctx, false, true, false, -1)
+			},
+			want: []string{
+				"stop instance web",
+				"delete instance web",
+				"stop instance database",
+				"delete instance database",
+				"delete volume shop-database-data",
+				"delete network shop",
+			},
+		},
+		{
+			name:  "snapshot",
+			setup: up,
+			plan: func(ctx context.Context, app *Compose) (*Plan, error) {
+				return app.PlanSnapshot(ctx, false, false, true)
+			},
+			apply: func(ctx context.Context, app *Compose) error {
+				return app.Snapshot(ctx, false, false, true)
+			},
+			want: []string{
+				"snapshot instance web",
+				"snapshot instance database",
+				"snapshot volume shop-database-data",
+			},
+		},
+		{
+			name:  "export volumes",
+			setup: up,
+			plan: func(ctx context.Context, app *Compose) (*Plan, error) {
+				return app.PlanExport(ctx, false, true)
+			},
+			apply: func(ctx context.Context, app *Compose) error {
+				return app.Export(ctx, false, true)
+			},
+			want: []string{"export volume shop-database-data"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			app, _ := newTestApp(t, planCompose)
+			if tt.setup != nil {
+				err := tt.setup(ctx, app)
+				if err != nil {
+					t.Fatalf("setup: %v", err)
+				}
+			}
+
+			plan, err := tt.plan(ctx, app)
+			if err != nil {
+				t.Fatalf("plan: %v", err)
+			}
+			if got := summary(plan.Actions); !slices.Equal(got, tt.want) {
+				t.Errorf("plan:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+
+			rec := &recorder{}
+			app.AddHook(rec)
+			err = tt.apply(ctx, app)
+			if err != nil {
+				t.Fatalf("apply: %v", err)
+			}
+			if got := summary(rec.actions); !slices.Equal(got, tt.want) {
+				t.Errorf("applied:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestPlanDoesNotChange(t *testing.T) {
+	ctx := context.Background()
+	app, fake := newTestApp(t, planCompose)
+
+	_, err := app.PlanUp(ctx, nil, RecreateNever, false)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(fake.calls) != 0 {
+		t.Errorf("planning made changes: %v", fake.calls)
+	}
+}
+
+func TestSnapshotVolumesOfNamedInstance(t *testing.T) {
+	ctx := context.Background()
+	app, fake := newTestApp(t, planCompose)
+	err := app.Up(ctx, nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	err = app.Snapshot(ctx, false, false, true)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	got := fake.called("CreateStoragePoolVolumeSnapshot")
+	if len(got) != 1 || !strings.HasPrefix(got[0], "CreateStoragePoolVolumeSnapshot default shop-database-data ") {
+		t.Errorf("volume snapshots: %v", got)
+	}
+	if got := fake.called("CreateInstanceSnapshot"); len(got) != 2 || !strings.HasPrefix(got[1], "CreateInstanceSnapshot database ") {
+		t.Errorf("instance snapshots: %v", got)
+	}
+}
+
+func TestPlanRemoveRunning(t *testing.T) {
+	ctx := context.Background()
+	app, _ := newTestApp(t, planCompose)
+	err := app.Up(ctx, nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	_, err = app.PlanRemove(ctx, []string{"web"}, -1, false, false, false)
+	if !errors.Is(err, types.ErrConflict) {
+		t.Errorf("plan: %v, want a conflict", err)
+	}
+	err = app.Remove(ctx, []string{"web"}, -1, false, false, false)
+	if !errors.Is(err, types.ErrConflict) {
+		t.Errorf("remove: %v, want a conflict", err)
+	}
+
+	// the default network is still used by db
+	plan, err := app.PlanRemove(ctx, []string{"web"}, -1, false, true, false)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	want := []string{"stop instance web", "delete instance web"}
+	if got := summary(plan.Actions); !slices.Equal(got, want) {
+		t.Errorf("plan: %v, want %v", got, want)
+	}
+}
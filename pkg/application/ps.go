@@ -0,0 +1,169 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// ServiceStatus describes the instance of a service.
+type ServiceStatus struct {
+	Service   string     `json:"service" yaml:"service"`
+	Instance  string     `json:"instance" yaml:"instance"`
+	Status    string     `json:"status" yaml:"status"`
+	Image     string     `json:"image" yaml:"image"`
+	Ports     []string   `json:"ports" yaml:"ports"`
+	Addresses []string   `json:"addresses" yaml:"addresses"`
+	StartedAt *time.Time `json:"started_at,omitempty" yaml:"started_at,omitempty"`
+	Uptime    string     `json:"uptime,omitempty" yaml:"uptime,omitempty"`
+}
+
+// statusNotCreated is reported for services without an instance
+const statusNotCreated = "Not created"
+
+// Ps returns the status of the instances of all services in start order.
+func (app *Compose) Ps(ctx context.Context) ([]ServiceStatus, error) {
+	statuses := []ServiceStatus{}
+
+	for _, service := range app.Order(true) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		svc, ok := app.Services[service]
+		if !ok {
+			return nil, &types.NotFoundError{Kind: "service", Name: service}
+		}
+		containerName := svc.GetContainerName()
+
+		status := ServiceStatus{
+			Service:   service,
+			Instance:  containerName,
+			Image:     svc.Image,
+			Ports:     []string{},
+			Addresses: []string{},
+		}
+
+		d, err := app.getInstanceServer(containerName)
+		if err != nil {
+			return nil, err
+		}
+		d = d.UseProject(app.GetProject())
+
+		inst, _, err := d.GetInstance(containerName)
+		if err != nil {
+			if !api.StatusErrorCheck(err, http.StatusNotFound) {
+				return nil, err
+			}
+			status.Status = statusNotCreated
+			statuses = append(statuses, status)
+			continue
+		}
+		status.Status = inst.Status
+		status.Ports = publishedPorts(inst.ExpandedDevices)
+
+		if inst.StatusCode == api.Running {
+			state, _, err := d.GetInstanceState(containerName)
+			if err != nil {
+				return nil, err
+			}
+			status.Addresses = instanceAddresses(state)
+			if !state.StartedAt.IsZero() {
+				startedAt := state.StartedAt
+				status.StartedAt = &startedAt
+				status.Uptime = time.Since(startedAt).Truncate(time.Second).String()
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// StatusFilter keeps the statuses whose key, status or service, has the
+// value
+type StatusFilter struct {
+	Key   string
+	Value string
+}
+
+// ParseStatusFilters parses key=value filters, the supported keys are status
+// and service
+func ParseStatusFilters(filters []string) ([]StatusFilter, error) {
+	parsed := []StatusFilter{}
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid filter %q, expected key=value", types.ErrUsage, filter)
+		}
+		if key != "status" && key != "service" {
+			return nil, fmt.Errorf("%w: unsupported filter %q", types.ErrUsage, key)
+		}
+		parsed = append(parsed, StatusFilter{Key: key, Value: value})
+	}
+	return parsed, nil
+}
+
+// FilterStatuses returns the statuses that match all filters
+func FilterStatuses(statuses []ServiceStatus, filters []StatusFilter) []ServiceStatus {
+	filtered := []ServiceStatus{}
+	for _, status := range statuses {
+		match := true
+		for _, filter := range filters {
+			switch filter.Key {
+			case "status":
+				match = match && strings.EqualFold(status.Status, filter.Value)
+			case "service":
+				match = match && status.Service == filter.Value
+			}
+		}
+		if match {
+			filtered = append(filtered, status)
+		}
+	}
+	return filtered
+}
+
+// publishedPorts lists the ports published with docker-port-* proxy devices
+// in the form listen-address:port->port/protocol
+func publishedPorts(devices map[string]map[string]string) []string {
+	ports := []string{}
+	for name, dev := range devices {
+		if !strings.HasPrefix(name, "docker-port-") || dev["type"] != "proxy" {
+			continue
+		}
+		// listen and connect are protocol:address:port
+		listen := strings.SplitN(dev["listen"], ":", 2)
+		connect := strings.Split(dev["connect"], ":")
+		if len(listen) != 2 || len(connect) < 3 {
+			continue
+		}
+		ports = append(ports, fmt.Sprintf("%s->%s/%s", listen[1], connect[len(connect)-1], listen[0]))
+	}
+	slices.Sort(ports)
+	return ports
+}
+
+// instanceAddresses lists the global addresses of all NICs as "address (nic)"
+func instanceAddresses(state *api.InstanceState) []string {
+	addresses := []string{}
+	for nic, network := range state.Network {
+		if network.Type == "loopback" {
+			continue
+		}
+		for _, addr := range network.Addresses {
+			if addr.Scope != "global" {
+				continue
+			}
+			addresses = append(addresses, fmt.Sprintf("%s (%s)", addr.Address, nic))
+		}
+	}
+	slices.Sort(addresses)
+	return addresses
+}
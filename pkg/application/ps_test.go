@@ -0,0 +1,31 @@
+package application
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+)
+
+func TestStatusFilters(t *testing.T) {
+	for _, filter := range []string{"bogus", "name=web"} {
+		_, err := ParseStatusFilters([]string{filter})
+		if !errors.Is(err, types.ErrUsage) {
+			t.Errorf("filter %q: error %v isn't a usage error", filter, err)
+		}
+	}
+
+	filters, err := ParseStatusFilters([]string{"status=running", "service=web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []ServiceStatus{
+		{Service: "db", Status: "Running"},
+		{Service: "web", Status: "Running"},
+		{Service: "web", Status: "Stopped"},
+	}
+	got := FilterStatuses(statuses, filters)
+	if len(got) != 1 || got[0].Service != "web" || got[0].Status != "Running" {
+		t.Errorf("filtered: %+v", got)
+	}
+}
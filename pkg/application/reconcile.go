@@ -0,0 +1,237 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/compose-spec/compose-go/v2/types"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// RecreatePolicy decides when existing instances are deleted and created
+// again instead of being updated in place.
+type RecreatePolicy int
+
+const (
+	// RecreateChanged recreates instances with changes that can't be
+	// applied in place.
+	RecreateChanged RecreatePolicy = iota
+	// RecreateAlways recreates all existing instances.
+	RecreateAlways
+	// RecreateNever never recreates instances, changes that can't be
+	// applied in place are skipped.
+	RecreateNever
+)
+
+// instanceDrift is the difference between the live instance and the
+// instance described by the compose file.
+type instanceDrift struct {
+	// changes are the differences that can be applied in place
+	changes []Drift
+	// recreate are the differences that need the instance to be recreated
+	recreate []Drift
+	// put is the live instance with the in-place changes applied
+	put api.InstancePut
+}
+
+// desiredInstance returns the instance a service should have once all its
+// volumes, bind mounts and secrets are attached.
+func (app *Compose) desiredInstance(d Backend, sc types.ServiceConfig, networks networkLookup) (*api.InstancesPost, error) {
+	instancePost, err := app.instanceForService(d, sc, networks)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := app.serviceDevices(sc.Name)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(instancePost.Devices, devices)
+
+	return instancePost, nil
+}
+
+// serviceDevices returns the devices attached to the instance of a service
+// once it's created, with the disk mounting its secrets, keyed by device
+// name.
+func (app *Compose) serviceDevices(service string) (map[string]map[string]string, error) {
+	devices, err := app.attachedDevices(service)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := app.secretFilesForService(service)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		source, err := filepath.Abs(secretsDir(service))
+		if err != nil {
+			return nil, err
+		}
+		devices[secretsDeviceName(service)] = secretsDevice(source)
+	}
+	return devices, nil
+}
+
+// diffInstance compares the live instance with the desired one. Only the
+// configuration incus-compose manages is compared: the image, environment
+// variables and devices from the compose file, user.* labels and snapshot
+// settings. Keys and devices set by the image or by hand are left alone.
+func diffInstance(desired api.InstancePut, live *api.Instance) instanceDrift {
+	drift := instanceDrift{put: live.Writable()}
+
+	// the image can only be changed by recreating the instance. Instances
+	// created before the image was recorded are taken to be up to date.
+	desiredConfig := desired.Config
+	if image, ok := live.Config[imageKey]; ok {
+		if image != desired.Config[imageKey] {
+			drift.recreate = append(drift.recreate, Drift{Kind: "image", Change: "changed"})
+		}
+		desiredConfig = maps.Clone(desired.Config)
+		desiredConfig[imageKey] = image
+	}
+
+	managedEnv := strings.Split(live.Config[environmentKey], ",")
+	drift.changes, drift.put.Config = diffConfig(desiredConfig, live.Config, func(k string) bool {
+		name, isEnv := strings.CutPrefix(k, "environment.")
+		return strings.HasPrefix(k, "user.") || strings.HasPrefix(k, "snapshots.") ||
+			(isEnv && slices.Contains(managedEnv, name))
+	})
+
+	drift.put.Devices = map[string]map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(desired.Devices)) {
+		dev := desired.Devices[name]
+		current, ok := live.Devices[name]
+		switch {
+		case !ok && name == "root":
+			drift.recreate = append(drift.recreate, Drift{Kind: "device", Name: name, Change: "added"})
+			continue
+		case !ok:
+			drift.changes = append(drift.changes, Drift{Kind: "device", Name: name, Change: "added"})
+		case !maps.Equal(current, dev) && name == "root":
+			drift.recreate = append(drift.recreate, Drift{Kind: "device", Name: name, Change: "changed"})
+			drift.put.Devices[name] = current
+			continue
+		case !maps.Equal(current, dev):
+			drift.changes = append(drift.changes, Drift{Kind: "device", Name: name, Change: "changed"})
+		}
+		drift.put.Devices[name] = dev
+	}
+	managedDevices := strings.Split(live.Config[devicesKey], ",")
+	for _, name := range slices.Sorted(maps.Keys(live.Devices)) {
+		if _, ok := desired.Devices[name]; ok {
+			continue
+		}
+		if !slices.Contains(managedDevices, name) {
+			drift.put.Devices[name] = live.Devices[name]
+			continue
+		}
+		if name == "root" {
+			drift.recreate = append(drift.recreate, Drift{Kind: "device", Name: name, Change: "removed"})
+			drift.put.Devices[name] = live.Devices[name]
+			continue
+		}
+		drift.changes = append(drift.changes, Drift{Kind: "device", Name: name, Change: "removed"})
+	}
+
+	if !slices.Equal(live.Profiles, desired.Profiles) {
+		drift.changes = append(drift.changes, Drift{Kind: "profiles", Change: "changed"})
+		drift.put.Profiles = desired.Profiles
+	}
+	if live.Description != desired.Description {
+		drift.changes = append(drift.changes, Drift{Kind: "description", Change: "changed"})
+		drift.put.Description = desired.Description
+	}
+
+	return drift
+}
+
+// diffConfig compares desired configuration with the live one and returns
+// the differences and the live configuration with them applied. Keys that
+// aren't desired are only removed when managed reports them as set by
+// incus-compose. Empty desired values count as unset.
+func diffConfig(desired, live map[string]string, managed func(key string) bool) ([]Drift, map[string]string) {
+	drifts := []Drift{}
+	config := maps.Clone(live)
+	if config == nil {
+		config = map[string]string{}
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(desired)) {
+		v := desired[k]
+		if v == "" {
+			continue
+		}
+		current, ok := live[k]
+		if !ok {
+			drifts = append(drifts, Drift{Kind: "config", Name: k, Change: "added"})
+		} else if current != v {
+			drifts = append(drifts, Drift{Kind: "config", Name: k, Change: "changed"})
+		}
+		config[k] = v
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(live)) {
+		if desired[k] != "" || !managed(k) {
+			continue
+		}
+		drifts = append(drifts, Drift{Kind: "config", Name: k, Change: "removed"})
+		delete(config, k)
+	}
+
+	return drifts, config
+}
+
+// reconcileInstance applies the changes to an existing instance that don't
+// need it to be recreated. The instance is compared with the desired one
+// again on every attempt, it may have changed since.
+func (app *Compose) reconcileInstance(ctx context.Context, d Backend, name string, desired api.InstancePut) error {
+	return app.retry(ctx, "update instance "+name, func() error {
+		inst, etag, err := d.GetInstance(name)
+		if err != nil {
+			return fmt.Errorf("failed loading instance %q: %w", name, err)
+		}
+		drift := diffInstance(desired, inst)
+		if len(drift.changes) == 0 {
+			return nil
+		}
+		op, err := d.UpdateInstance(name, drift.put, etag)
+		if err != nil {
+			return err
+		}
+		return app.wait(ctx, op)
+	})
+}
+
+// recreateInstance deletes the instance of a service and creates it again.
+// The new instance and its image are prepared before the old instance is
+// deleted, so a bad compose file or a missing image leaves it in place.
+// Custom volumes are kept and attached again afterwards.
+func (app *Compose) recreateInstance(ctx context.Context, d Backend, remote string, sc types.ServiceConfig) error {
+	instancePost, err := app.instanceForService(d, sc, liveNetworks(d))
+	if err != nil {
+		return err
+	}
+	imgRemote, imgInfo, err := app.instanceImage(d, remote, sc.Image, instancePost)
+	if err != nil {
+		return err
+	}
+
+	err = app.removeInstance(ctx, instancePost.Name, true)
+	if err != nil {
+		return err
+	}
+
+	err = app.submitInstance(ctx, d, imgRemote, imgInfo, instancePost)
+	if err != nil {
+		return err
+	}
+	slog.Info("Created instance", slog.String("name", instancePost.Name))
+	return nil
+}
@@ -0,0 +1,372 @@
+package application
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+func TestDiffInstanceKeepsHandAddedDevices(t *testing.T) {
+	app, fake := newTestApp(t, `
+name: shop
+services:
+  db:
+    image: alpine
+    volumes:
+      - data:/var/lib/db
+  web:
+    image: alpine
+    depends_on:
+      - db
+    ports:
+      - "8080:80"
+volumes:
+  data: {}
+`)
+	err := app.Up(context.Background(), nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	// incus config device add web gpu0 gpu
+	fake.instance("web").Devices["gpu0"] = map[string]string{"type": "gpu"}
+
+	// the port is dropped from the compose file
+	app, _ = newTestApp(t, testCompose)
+	app.connect = fake.connect
+
+	d, err := app.getInstanceServer("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = d.UseProject(app.GetProject())
+	sc, err := app.ComposeProject.GetService("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desired, err := app.desiredInstance(d, sc, liveNetworks(d))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drift := diffInstance(desired.InstancePut, fake.instance("web"))
+	devices := []Drift{}
+	for _, change := range drift.changes {
+		if change.Kind == "device" {
+			devices = append(devices, change)
+		}
+	}
+	want := []Drift{{Kind: "device", Name: "docker-port-0.0.0.0-8080", Change: "removed"}}
+	if !slices.Equal(devices, want) || len(drift.recreate) != 0 {
+		t.Errorf("device drift: %v, recreate: %v, want %v", devices, drift.recreate, want)
+	}
+
+	err = app.Up(context.Background(), nil, RecreateChanged, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	live := fake.instance("web").Devices
+	if _, ok := live["gpu0"]; !ok {
+		t.Errorf("hand added device removed: %v", live)
+	}
+	if _, ok := live["docker-port-0.0.0.0-8080"]; ok {
+		t.Errorf("port not removed: %v", live)
+	}
+}
+
+// withImage is testCompose with another image for web
+func withImage(image string) string {
+	return strings.Replace(testCompose, "image: alpine\n    depends_on", "image: "+image+"\n    depends_on", 1)
+}
+
+func TestRecreateChangedImage(t *testing.T) {
+	ctx := context.Background()
+	app, fake := newTestApp(t, testCompose)
+	err := app.Up(ctx, nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	app, _ = newTestApp(t, withImage("debian"))
+	app.connect = fake.connect
+
+	plan, err := app.PlanUp(ctx, nil, RecreateChanged, false)
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	recreate := slices.ContainsFunc(plan.Actions, func(a Action) bool {
+		return a.Verb == "recreate" && a.Name == "web" && a.Details["reason"] == "image changed"
+	})
+	if !recreate {
+		t.Errorf("plan doesn't recreate web: %v", summary(plan.Actions))
+	}
+
+	// the image isn't changed in place
+	err = app.Up(ctx, nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if got := fake.called("DeleteInstance"); len(got) != 0 {
+		t.Errorf("instances deleted: %v", got)
+	}
+	if image := fake.instance("web").Config[imageKey]; image != "alpine" {
+		t.Errorf("image label changed in place to %q", image)
+	}
+
+	err = app.Up(ctx, nil, RecreateChanged, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if got := fake.called("DeleteInstance"); !slices.Equal(got, []string{"DeleteInstance web"}) {
+		t.Errorf("instances deleted: %v", got)
+	}
+	if image := fake.instance("web").Config[imageKey]; image != "debian" {
+		t.Errorf("web created from %q, want debian", image)
+	}
+}
+
+func TestRecreateKeepsInstanceWithoutImage(t *testing.T) {
+	ctx := context.Background()
+	app, fake := newTestApp(t, testCompose)
+	err := app.Up(ctx, nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+
+	app, _ = newTestApp(t, withImage("missing:alpine"))
+	app.connect = fake.connect
+
+	err = app.Up(ctx, nil, RecreateChanged, false, false)
+	if err == nil {
+		t.Fatal("up with an unknown image remote succeeded")
+	}
+	if got := fake.called("DeleteInstance"); len(got) != 0 {
+		t.Errorf("instances deleted: %v", got)
+	}
+	if fake.instance("web") == nil {
+		t.Error("web is gone")
+	}
+}
+
+func TestDiffConfig(t *testing.T) {
+	managed := func(k string) bool { return strings.HasPrefix(k, "user.") }
+	tests := []struct {
+		name    string
+		desired map[string]string
+		live    map[string]string
+		want    []string
+		config  map[string]string
+	}{
+		{
+			name:    "unchanged",
+			desired: map[string]string{"user.a": "1"},
+			live:    map[string]string{"user.a": "1"},
+			want:    []string{},
+			config:  map[string]string{"user.a": "1"},
+		},
+		{
+			name:    "added and changed",
+			desired: map[string]string{"user.a": "1", "user.b": "2"},
+			live:    map[string]string{"user.b": "1"},
+			want:    []string{"config user.a added", "config user.b changed"},
+			config:  map[string]string{"user.a": "1", "user.b": "2"},
+		},
+		{
+			name:    "managed key removed",
+			desired: map[string]string{},
+			live:    map[string]string{"user.a": "1"},
+			want:    []string{"config user.a removed"},
+			config:  map[string]string{},
+		},
+		{
+			name:    "unmanaged key kept",
+			desired: map[string]string{},
+			live:    map[string]string{"limits.cpu": "2"},
+			want:    []string{},
+			config:  map[string]string{"limits.cpu": "2"},
+		},
+		{
+			name:    "empty value is unset",
+			desired: map[string]string{"user.a": "", "limits.cpu": ""},
+			live:    map[string]string{"user.a": "1", "limits.cpu": "2"},
+			want:    []string{"config user.a removed"},
+			config:  map[string]string{"limits.cpu": "2"},
+		},
+		{
+			name:    "no live config",
+			desired: map[string]string{"user.a": "1"},
+			want:    []string{"config user.a added"},
+			config:  map[string]string{"user.a": "1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := maps.Clone(tt.live)
+			drifts, config := diffConfig(tt.desired, tt.live, managed)
+
+			got := []string{}
+			for _, d := range drifts {
+				got = append(got, d.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("drift: %v, want %v", got, tt.want)
+			}
+			if !maps.Equal(config, tt.config) {
+				t.Errorf("config: %v, want %v", config, tt.config)
+			}
+			if !maps.Equal(tt.live, live) {
+				t.Errorf("live config changed: %v", tt.live)
+			}
+		})
+	}
+}
+
+func TestDiffInstance(t *testing.T) {
+	nic := map[string]string{"type": "nic", "network": "shop", "name": "eth0"}
+	data := map[string]string{"type": "disk", "pool": "default", "source": "shop-db-data", "path": "/var/lib/db"}
+	root := map[string]string{"type": "disk", "pool": "default", "path": "/"}
+	gpu := map[string]string{"type": "gpu"}
+
+	tests := []struct {
+		name     string
+		desired  api.InstancePut
+		live     api.InstancePut
+		changes  []string
+		recreate []string
+		// config and devices are checked on the instance to put, when set
+		config   map[string]string
+		devices  []string
+		profiles []string
+	}{
+		{
+			name: "up to date",
+			desired: api.InstancePut{
+				Config:   map[string]string{"environment.A": "1", environmentKey: "A", imageKey: "alpine"},
+				Devices:  map[string]map[string]string{"eth0": nic},
+				Profiles: []string{"default"},
+			},
+			live: api.InstancePut{
+				Config:   map[string]string{"environment.A": "1", environmentKey: "A", imageKey: "alpine", "volatile.base_image": "abc"},
+				Devices:  map[string]map[string]string{"eth0": nic},
+				Profiles: []string{"default"},
+			},
+			config: map[string]string{"environment.A": "1", environmentKey: "A", imageKey: "alpine", "volatile.base_image": "abc"},
+		},
+		{
+			name: "managed environment",
+			desired: api.InstancePut{
+				Config: map[string]string{"environment.A": "2", "environment.C": "3", environmentKey: "A,C"},
+			},
+			live: api.InstancePut{
+				Config: map[string]string{"environment.A": "1", "environment.B": "1", environmentKey: "A,B"},
+			},
+			changes: []string{"config environment.A changed", "config environment.C added", "config " + environmentKey + " changed", "config environment.B removed"},
+			config:  map[string]string{"environment.A": "2", "environment.C": "3", environmentKey: "A,C"},
+		},
+		{
+			name: "environment of the image",
+			desired: api.InstancePut{
+				Config: map[string]string{"environment.A": "1", environmentKey: "A"},
+			},
+			live: api.InstancePut{
+				Config: map[string]string{"environment.A": "1", "environment.PATH": "/bin", environmentKey: "A"},
+			},
+			config: map[string]string{"environment.A": "1", "environment.PATH": "/bin", environmentKey: "A"},
+		},
+		{
+			name: "devices",
+			desired: api.InstancePut{
+				Config:  map[string]string{devicesKey: "eth0,shop-db-data"},
+				Devices: map[string]map[string]string{"eth0": {"type": "nic", "network": "other", "name": "eth0"}, "shop-db-data": data},
+			},
+			live: api.InstancePut{
+				Config:  map[string]string{devicesKey: "docker-port-0.0.0.0-8080,eth0"},
+				Devices: map[string]map[string]string{"eth0": nic, "docker-port-0.0.0.0-8080": {"type": "proxy"}, "gpu0": gpu},
+			},
+			changes: []string{"config " + devicesKey + " changed", "device eth0 changed", "device shop-db-data added", "device docker-port-0.0.0.0-8080 removed"},
+			devices: []string{"eth0", "gpu0", "shop-db-data"},
+		},
+		{
+			name: "root device",
+			desired: api.InstancePut{
+				Config:  map[string]string{devicesKey: "root"},
+				Devices: map[string]map[string]string{"root": {"type": "disk", "pool": "fast", "path": "/"}},
+			},
+			live: api.InstancePut{
+				Config:  map[string]string{devicesKey: "root"},
+				Devices: map[string]map[string]string{"root": root},
+			},
+			recreate: []string{"device root changed"},
+			devices:  []string{"root"},
+		},
+		{
+			name: "profiles and description",
+			desired: api.InstancePut{
+				Profiles:    []string{"default", "gpu"},
+				Description: "shop-web",
+			},
+			live: api.InstancePut{
+				Profiles:    []string{"default"},
+				Description: "web",
+			},
+			changes:  []string{"profiles changed", "description changed"},
+			profiles: []string{"default", "gpu"},
+		},
+		{
+			name: "image",
+			desired: api.InstancePut{
+				Config: map[string]string{imageKey: "debian"},
+			},
+			live: api.InstancePut{
+				Config: map[string]string{imageKey: "alpine"},
+			},
+			recreate: []string{"image changed"},
+			config:   map[string]string{imageKey: "alpine"},
+		},
+		{
+			name: "image not recorded",
+			desired: api.InstancePut{
+				Config: map[string]string{imageKey: "debian"},
+			},
+			live:    api.InstancePut{Config: map[string]string{}},
+			changes: []string{"config " + imageKey + " added"},
+			config:  map[string]string{imageKey: "debian"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := &api.Instance{Name: "web", InstancePut: tt.live}
+			drift := diffInstance(tt.desired, live)
+
+			changes := []string{}
+			for _, d := range drift.changes {
+				changes = append(changes, d.String())
+			}
+			if !slices.Equal(changes, tt.changes) {
+				t.Errorf("changes: %v, want %v", changes, tt.changes)
+			}
+			recreate := []string{}
+			for _, d := range drift.recreate {
+				recreate = append(recreate, d.String())
+			}
+			if !slices.Equal(recreate, tt.recreate) {
+				t.Errorf("recreate: %v, want %v", recreate, tt.recreate)
+			}
+
+			if tt.config != nil && !maps.Equal(drift.put.Config, tt.config) {
+				t.Errorf("config: %v, want %v", drift.put.Config, tt.config)
+			}
+			if tt.devices != nil {
+				if got := slices.Sorted(maps.Keys(drift.put.Devices)); !slices.Equal(got, tt.devices) {
+					t.Errorf("devices: %v, want %v", got, tt.devices)
+				}
+			}
+			if tt.profiles != nil && !slices.Equal(drift.put.Profiles, tt.profiles) {
+				t.Errorf("profiles: %v, want %v", drift.put.Profiles, tt.profiles)
+			}
+		})
+	}
+}
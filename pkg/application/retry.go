@@ -0,0 +1,116 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// Retry controls how Incus calls failing on transient errors are retried
+type Retry struct {
+	// Attempts is how many times a call is made, 1 or less means no retries
+	Attempts int
+	// Backoff is the delay before the first retry, it doubles with every
+	// retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetry makes three attempts, waiting one then two seconds
+var DefaultRetry = Retry{Attempts: 3, Backoff: time.Second, MaxBackoff: 30 * time.Second}
+
+// retry calls fn until it succeeds, fails with an error that isn't
+// transient or runs out of attempts. fn must fetch what it changes, like the
+// etag of an instance, so that a retry after a precondition failure works on
+// the current version.
+func (app *Compose) retry(ctx context.Context, call string, fn func() error) error {
+	backoff := app.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= app.Retry.Attempts || !transient(err) || ctx.Err() != nil {
+			return err
+		}
+
+		slog.Warn("Retrying", slog.String("call", call), slog.Int("attempt", attempt), slog.Duration("backoff", backoff), slog.String("error", err.Error()))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if app.Retry.MaxBackoff > 0 && backoff > app.Retry.MaxBackoff {
+			backoff = app.Retry.MaxBackoff
+		}
+	}
+}
+
+// submit sends a request that isn't idempotent, like creating a resource,
+// retrying only the sending and not the operation it starts. A request may
+// be accepted before its connection fails: when a retry then fails, made
+// reports whether an earlier attempt already made the change, which then
+// counts as done. wait is false in that case, there's no operation to wait
+// for.
+func (app *Compose) submit(ctx context.Context, call string, send func() error, made func(err error) bool) (wait bool, err error) {
+	attempted := false
+	wait = true
+	err = app.retry(ctx, call, func() error {
+		err := send()
+		if err != nil && attempted && made != nil && made(err) {
+			slog.Info("Made by an earlier attempt", slog.String("call", call))
+			wait = false
+			return nil
+		}
+		attempted = true
+		return err
+	})
+	return wait && err == nil, err
+}
+
+// transient reports whether a failed call may succeed when made again: the
+// resource changed since it was fetched, the server is overloaded or
+// unavailable, or the connection to it timed out, was refused or was cut.
+// Other connection failures, like certificate errors, won't go away.
+func transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if api.StatusErrorCheck(err, http.StatusPreconditionFailed, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// updateInstance applies update to the current configuration of an instance
+// and reports whether it changed. update returns false to leave the instance
+// as it is. When the instance changed in between the update is retried on
+// the instance fetched again.
+func (app *Compose) updateInstance(ctx context.Context, d Backend, name string, update func(inst *api.Instance) (bool, error)) (bool, error) {
+	changed := false
+	err := app.retry(ctx, "update instance "+name, func() error {
+		inst, etag, err := d.GetInstance(name)
+		if err != nil {
+			return err
+		}
+		changed, err = update(inst)
+		if err != nil || !changed {
+			return err
+		}
+		op, err := d.UpdateInstance(name, inst.Writable(), etag)
+		if err != nil {
+			return err
+		}
+		return app.wait(ctx, op)
+	})
+	return changed, err
+}
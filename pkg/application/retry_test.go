@@ -0,0 +1,238 @@
+package application
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	incus "github.com/lxc/incus/v6/client"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// flakyCreate fails creating instances after the server accepted the
+// request, or fails the create operation itself
+type flakyCreate struct {
+	Backend
+	dropped bool
+	waitErr error
+}
+
+func (b *flakyCreate) UseProject(name string) Backend {
+	return &flakyCreate{Backend: b.Backend.UseProject(name), waitErr: b.waitErr}
+}
+
+func (b *flakyCreate) CreateInstanceFromImage(source ImageSource, image api.Image, req api.InstancesPost) (incus.RemoteOperation, error) {
+	op, err := b.Backend.CreateInstanceFromImage(source, image, req)
+	if err != nil {
+		return nil, err
+	}
+	if b.waitErr != nil {
+		return &fakeOperation{err: b.waitErr}, nil
+	}
+	if !b.dropped {
+		b.dropped = true
+		return nil, syscall.ECONNRESET
+	}
+	return op, nil
+}
+
+func TestCreateInstanceAcceptedBeforeFailure(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+	app.connect = func(remote string) (Backend, error) {
+		d, err := fake.connect(remote)
+		return &flakyCreate{Backend: d}, err
+	}
+
+	err := app.Up(context.Background(), []string{"db"}, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	// the retry found the instance created by the first attempt
+	if got := fake.called("CreateInstance"); !slices.Equal(got, []string{"CreateInstance db", "CreateInstance db"}) {
+		t.Errorf("instances created: %v", got)
+	}
+	if fake.instance("db") == nil {
+		t.Error("instance db doesn't exist")
+	}
+}
+
+func TestCreateInstanceWaitNotRetried(t *testing.T) {
+	app, fake := newTestApp(t, testCompose)
+	failed := &types.IncusError{Remote: "local", Err: syscall.ECONNRESET}
+	app.connect = func(remote string) (Backend, error) {
+		d, err := fake.connect(remote)
+		return &flakyCreate{Backend: d, waitErr: failed}, err
+	}
+
+	err := app.Up(context.Background(), []string{"db"}, RecreateNever, false, false)
+	if !errors.Is(err, failed) {
+		t.Fatalf("error %v isn't the failure of the create operation", err)
+	}
+	if got := fake.called("CreateInstance"); !slices.Equal(got, []string{"CreateInstance db"}) {
+		t.Errorf("instances created: %v", got)
+	}
+}
+
+// dropResponses makes the server accept the first request of each method,
+// then fails it as if the connection was reset before the response
+type dropResponses struct {
+	Backend
+	*dropped
+}
+
+type dropped struct {
+	mu      sync.Mutex
+	methods map[string]bool
+}
+
+// drop reports whether the response of method is dropped
+func (d *dropped) drop(method string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.methods[method] {
+		return false
+	}
+	delete(d.methods, method)
+	return true
+}
+
+func (b *dropResponses) UseProject(name string) Backend {
+	return &dropResponses{Backend: b.Backend.UseProject(name), dropped: b.dropped}
+}
+
+func (b *dropResponses) CreateStoragePoolVolume(pool string, volume api.StorageVolumesPost) error {
+	err := b.Backend.CreateStoragePoolVolume(pool, volume)
+	if err == nil && b.drop("CreateStoragePoolVolume") {
+		return syscall.ECONNRESET
+	}
+	return err
+}
+
+func (b *dropResponses) UpdateInstanceState(name string, state api.InstanceStatePut, ETag string) (incus.Operation, error) {
+	op, err := b.Backend.UpdateInstanceState(name, state, ETag)
+	if err == nil && b.drop("UpdateInstanceState") {
+		return nil, syscall.ECONNRESET
+	}
+	return op, err
+}
+
+func (b *dropResponses) CreateInstanceSnapshot(instanceName string, snapshot api.InstanceSnapshotsPost) (incus.Operation, error) {
+	op, err := b.Backend.CreateInstanceSnapshot(instanceName, snapshot)
+	if err == nil && b.drop("CreateInstanceSnapshot") {
+		return nil, syscall.ECONNRESET
+	}
+	return op, err
+}
+
+func (b *dropResponses) CreateStoragePoolVolumeSnapshot(pool string, volumeType string, volumeName string, snapshot api.StorageVolumeSnapshotsPost) (incus.Operation, error) {
+	op, err := b.Backend.CreateStoragePoolVolumeSnapshot(pool, volumeType, volumeName, snapshot)
+	if err == nil && b.drop("CreateStoragePoolVolumeSnapshot") {
+		return nil, syscall.ECONNRESET
+	}
+	return op, err
+}
+
+func (b *dropResponses) CreateInstanceBackup(instanceName string, backup api.InstanceBackupsPost) (incus.Operation, error) {
+	op, err := b.Backend.CreateInstanceBackup(instanceName, backup)
+	if err == nil && b.drop("CreateInstanceBackup") {
+		return nil, syscall.ECONNRESET
+	}
+	return op, err
+}
+
+func TestSubmitAcceptedBeforeFailure(t *testing.T) {
+	ctx := context.Background()
+	app, fake := newTestApp(t, testCompose)
+	drop := &dropped{methods: map[string]bool{
+		"CreateStoragePoolVolume":         true,
+		"UpdateInstanceState":             true,
+		"CreateInstanceSnapshot":          true,
+		"CreateStoragePoolVolumeSnapshot": true,
+		"CreateInstanceBackup":            true,
+	}}
+	app.connect = func(remote string) (Backend, error) {
+		d, err := fake.connect(remote)
+		return &dropResponses{Backend: d, dropped: drop}, err
+	}
+
+	err := app.Up(ctx, nil, RecreateNever, false, false)
+	if err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	err = app.Snapshot(ctx, false, false, true)
+	if err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	err = app.Export(ctx, false, false)
+	if err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	if len(drop.methods) != 0 {
+		t.Fatalf("responses not dropped: %v", drop.methods)
+	}
+
+	// the retries found what the accepted requests made
+	calls := map[string]int{
+		"CreateStoragePoolVolume":         1,
+		"UpdateInstanceState":             2,
+		"CreateInstanceSnapshot":          2,
+		"CreateStoragePoolVolumeSnapshot": 1,
+	}
+	for method, want := range calls {
+		if got := fake.called(method); len(got) != want {
+			t.Errorf("%s made %d times, want %d: %v", method, len(got), want, got)
+		}
+	}
+	// backups are named by the server, the retry makes another one
+	if got := fake.called("CreateInstanceBackup"); len(got) != 3 {
+		t.Errorf("backups made: %v", got)
+	}
+}
+
+// timeoutError is a network error that may or may not be a timeout
+type timeoutError bool
+
+func (e timeoutError) Error() string   { return "network error" }
+func (e timeoutError) Timeout() bool   { return bool(e) }
+func (e timeoutError) Temporary() bool { return false }
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"precondition failed", api.StatusErrorf(http.StatusPreconditionFailed, "changed"), true},
+		{"too many requests", api.StatusErrorf(http.StatusTooManyRequests, "slow down"), true},
+		{"unavailable", api.StatusErrorf(http.StatusServiceUnavailable, "unavailable"), true},
+		{"gateway timeout", api.StatusErrorf(http.StatusGatewayTimeout, "timeout"), true},
+		{"not found", api.StatusErrorf(http.StatusNotFound, "missing"), false},
+		{"conflict", api.StatusErrorf(http.StatusConflict, "exists"), false},
+		{"timeout", &url.Error{Op: "Get", URL: "https://incus", Err: timeoutError(true)}, true},
+		{"connection reset", &url.Error{Op: "Get", URL: "https://incus", Err: &net.OpError{Op: "read", Err: syscall.ECONNRESET}}, true},
+		{"connection refused", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{"unexpected EOF", fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{"network error", &url.Error{Op: "Get", URL: "https://incus", Err: timeoutError(false)}, false},
+		{"unknown certificate authority", &url.Error{Op: "Get", URL: "https://incus", Err: x509.UnknownAuthorityError{}}, false},
+		{"no such host", &net.DNSError{Err: "no such host", Name: "incus", IsNotFound: true}, false},
+		{"cancelled", &url.Error{Op: "Get", URL: "https://incus", Err: context.Canceled}, false},
+		{"other", errors.New("invalid"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transient(tt.err); got != tt.want {
+				t.Errorf("transient(%v) = %t, want %t", tt.err, got, tt.want)
+			}
+		})
+	}
+}
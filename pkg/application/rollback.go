@@ -0,0 +1,132 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// rollback records the actions of a run so they can be undone when the run
+// fails
+type rollback struct {
+	mu      sync.Mutex
+	actions []Action
+}
+
+func (r *rollback) Applied(action Action) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.actions = append(r.actions, action)
+}
+
+// Submitted records the actions that failed after their change was
+// submitted, the resources they created are removed too
+func (r *rollback) Submitted(action Action) {
+	r.Applied(action)
+}
+
+// undo reverts the recorded actions in reverse order. Resources that
+// existed before the run are left in place: only the devices attached to
+// them are detached and the instances started are stopped again. In-place
+// updates and recreated instances can't be undone and are only reported.
+// Undoing continues past errors, which are returned together.
+func (r *rollback) undo(ctx context.Context, app *Compose) error {
+	r.mu.Lock()
+	actions := slices.Clone(r.actions)
+	r.mu.Unlock()
+
+	// devices and state of instances created by the run go away with them
+	created := map[string]bool{}
+	for _, a := range actions {
+		if a.Verb == "create" && a.Kind == "instance" {
+			created[a.Name] = true
+		}
+	}
+
+	var errs []error
+	for _, a := range slices.Backward(actions) {
+		var err error
+		switch {
+		case a.Verb == "start" && a.Kind == "instance":
+			if created[a.Name] {
+				continue
+			}
+			slog.Info("Rolling back", slog.String("action", "stop"), slog.String("instance", a.Name))
+			err = app.updateInstanceState(ctx, a.Name, "stop", -1, true, false)
+		case a.Verb == "attach" && a.Kind == "device":
+			instance, device, _ := strings.Cut(a.Name, "/")
+			if created[instance] {
+				continue
+			}
+			slog.Info("Rolling back", slog.String("action", "detach"), slog.String("instance", instance), slog.String("device", device))
+			err = app.removeDevice(ctx, instance, device)
+		case a.Verb == "create" && a.Kind == "instance":
+			slog.Info("Rolling back", slog.String("action", "delete"), slog.String("instance", a.Name))
+			err = app.removeInstance(ctx, a.Name, true)
+			// a create that failed after it was submitted may not have
+			// made the instance
+			if api.StatusErrorCheck(err, http.StatusNotFound) {
+				err = nil
+			}
+		case a.Verb == "create" && a.Kind == "volume":
+			slog.Info("Rolling back", slog.String("action", "delete"), slog.String("volume", a.Name))
+			err = app.deleteVolume(a.Name, Volume{Name: a.Name, Pool: a.Details["pool"]})
+		case a.Verb == "create" && a.Kind == "network":
+			slog.Info("Rolling back", slog.String("action", "delete"), slog.String("network", a.Name))
+			err = app.DestroyDefaultNetwork(ctx)
+		default:
+			slog.Warn("Can't roll back", slog.String("action", a.Verb), slog.String(a.Kind, a.Name))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s %s: %w", a.Verb, a.Kind, a.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withRollback runs fn and undoes the resources it created when it fails.
+// Rolling back isn't cancelled with ctx, a run that timed out or was
+// interrupted is rolled back too.
+func (app *Compose) withRollback(ctx context.Context, fn func() error) error {
+	r := &rollback{}
+	app.AddHook(r)
+	defer app.RemoveHook(r)
+
+	err := fn()
+	if err == nil {
+		return nil
+	}
+
+	slog.Warn("Rolling back", slog.String("error", err.Error()))
+	rerr := r.undo(context.WithoutCancel(ctx), app)
+	if rerr != nil {
+		return errors.Join(err, fmt.Errorf("rollback failed: %w", rerr))
+	}
+	slog.Info("Rolled back")
+	return err
+}
+
+// removeDevice detaches a device from an instance
+func (app *Compose) removeDevice(ctx context.Context, instance, name string) error {
+	d, err := app.getInstanceServer(instance)
+	if err != nil {
+		return err
+	}
+	d = d.UseProject(app.GetProject())
+
+	_, err = app.updateInstance(ctx, d, instance, func(inst *api.Instance) (bool, error) {
+		if _, ok := inst.Devices[name]; !ok {
+			return false, nil
+		}
+		delete(inst.Devices, name)
+		return true, nil
+	})
+	return err
+}
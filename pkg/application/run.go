@@ -0,0 +1,138 @@
+package application
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// RunContainerForService creates a one-off copy of a service instance, runs a
+// command in it and returns the exit code of the command. When remove is true
+// the instance is ephemeral and is deleted once the command has finished,
+// otherwise it is left stopped. An empty command runs the service's command
+// from the compose file. When ctx is done while the command runs, the instance
+// is still stopped and removed.
+func (app *Compose) RunContainerForService(ctx context.Context, service string, command []string, remove bool, opts ExecOptions) (int, error) {
+	slog.Info("Run", slog.String("instance", service))
+
+	sc, err := app.ComposeProject.GetService(service)
+	if err != nil {
+		return -1, err
+	}
+	if len(command) == 0 {
+		command = sc.Command
+	}
+	if len(command) == 0 {
+		return -1, fmt.Errorf("no command given and service %s has no command", service)
+	}
+
+	// Parse the remote
+	remote, _, err := app.conf.ParseRemote(service)
+	if err != nil {
+		return -1, err
+	}
+
+	d, err := app.backend(remote)
+	if err != nil {
+		return -1, err
+	}
+
+	d = d.UseProject(app.GetProject())
+
+	instancePost, err := app.instanceForService(d, sc, liveNetworks(d))
+	if err != nil {
+		return -1, err
+	}
+
+	instancePost.Name, err = runName(instancePost.Name)
+	if err != nil {
+		return -1, err
+	}
+	instancePost.Ephemeral = remove
+	instancePost.Config[runKey] = "true"
+
+	// published ports belong to the service instance, they would conflict
+	for name := range instancePost.Devices {
+		if strings.HasPrefix(name, "docker-port-") {
+			delete(instancePost.Devices, name)
+		}
+	}
+
+	// share volumes, bind mounts and secrets with the service
+	err = app.CreateVolumesForService(ctx, service)
+	if err != nil {
+		return -1, err
+	}
+	devices, err := app.attachedDevices(service)
+	if err != nil {
+		return -1, err
+	}
+	maps.Copy(instancePost.Devices, devices)
+
+	secretsPath, err := app.writeSecretsForService(service)
+	if err != nil {
+		return -1, err
+	}
+	if secretsPath != "" {
+		instancePost.Devices[secretsDeviceName(service)] = secretsDevice(secretsPath)
+	}
+
+	err = app.createInstance(ctx, d, remote, sc.Image, instancePost)
+	if err != nil {
+		return -1, err
+	}
+	slog.Info("Created instance", slog.String("name", instancePost.Name))
+
+	cleanup := func() error {
+		ctx := context.WithoutCancel(ctx)
+
+		slog.Info("Stopping", slog.String("instance", instancePost.Name))
+		inst, _, err := d.GetInstance(instancePost.Name)
+		if err != nil {
+			if remove && api.StatusErrorCheck(err, http.StatusNotFound) {
+				return nil
+			}
+			return err
+		}
+		if inst.StatusCode == api.Running {
+			err = app.updateInstanceState(ctx, instancePost.Name, "stop", -1, true, false)
+			if err != nil {
+				return err
+			}
+		}
+		if remove && !inst.Ephemeral {
+			return app.removeInstance(ctx, instancePost.Name, true)
+		}
+		return nil
+	}
+
+	err = app.updateInstanceState(ctx, instancePost.Name, "start", -1, false, false)
+	if err != nil {
+		return -1, errors.Join(err, cleanup())
+	}
+
+	code, err := execInstance(ctx, d, instancePost.Name, command, opts)
+	if err != nil {
+		return code, errors.Join(err, cleanup())
+	}
+
+	return code, cleanup()
+}
+
+// runName returns a unique name for a one-off instance of a service
+func runName(containerName string) (string, error) {
+	b := make([]byte, 3)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-run-%s", containerName, hex.EncodeToString(b)), nil
+}
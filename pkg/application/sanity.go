@@ -1,42 +1,44 @@
 package application
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"maps"
 	"slices"
 
-	incus "github.com/lxc/incus/v6/client"
+	"github.com/bketelsen/incus-compose/pkg/types"
 )
 
-type SanityCheckError struct {
-	Step string
-	Err  error
-}
-
-func (e *SanityCheckError) Error() string { return "Sanity Check: " + e.Step + " -> " + e.Err.Error() }
-
-func (app *Compose) SanityCheck() error {
+// SanityCheck checks that the project, profiles, storage pools and networks
+// the compose file refers to exist on the remote. The project, profiles and
+// pools are checked with the validate tags of the application against the
+// names looked up here. Every missing resource is reported, located at the
+// key of the compose file naming it.
+func (app *Compose) SanityCheck(ctx context.Context) error {
 	var err error
 	var remote string
-	var d incus.InstanceServer
+	var d Backend
 	var projectNames []string
-	var profileNames []string
-	var poolNames []string
-	var netNames []string
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
 
 	// check to see if the incus connection is valid
 	// get the first service and try to connect to the incus remote
 	for _, service := range app.Services {
 		remote, _, err = app.conf.ParseRemote(service.Name)
 		if err != nil {
-			return &SanityCheckError{
+			return &types.SanityCheckError{
 				Step: "parse incus remote",
 				Err:  fmt.Errorf("error parsing remote: %s", err),
 			}
 		}
 
-		d, err = app.conf.GetInstanceServer(remote)
+		d, err = app.backend(remote)
 		if err != nil {
-			return &SanityCheckError{
+			return &types.SanityCheckError{
 				Step: "get incus remote",
 				Err:  fmt.Errorf("error getting instance server: %s", err),
 			}
@@ -47,102 +49,104 @@ func (app *Compose) SanityCheck() error {
 			// get the project names while we're connected
 			projectNames, err = d.GetProjectNames()
 			if err != nil {
-				return &SanityCheckError{
+				return &types.SanityCheckError{
 					Step: "get project names",
 					Err:  fmt.Errorf("error getting project names: %s", err),
 				}
 			}
 		}
 	}
-	// check to see if the project exists
-	if !slices.Contains(projectNames, app.GetProject()) {
-		return &SanityCheckError{
-			Step: "check declared project exists",
-			Err:  fmt.Errorf("project '%s' does not exist", app.GetProject()),
-		}
+
+	// the other resources are looked up in the project
+	names := &remoteNames{projects: projectNames}
+	err = app.checkStruct(names, "Project")
+	if err != nil {
+		return err
 	}
-	// check to see if the profiles exist
+
 	d = d.UseProject(app.GetProject())
-	profileNames, err = d.GetProfileNames()
+	names.profiles, err = d.GetProfileNames()
 	if err != nil {
-		return &SanityCheckError{
+		return &types.SanityCheckError{
 			Step: "get profile names",
 			Err:  fmt.Errorf("error getting profile names: %s", err),
 		}
 	}
-	poolNames, err = d.GetStoragePoolNames()
+	names.pools, err = d.GetStoragePoolNames()
 	if err != nil {
-		return &SanityCheckError{
+		return &types.SanityCheckError{
 			Step: "get storage pool names",
 			Err:  fmt.Errorf("error getting storage pool names: %s", err),
 		}
 	}
-	netNames, err = d.GetNetworkNames()
+	netNames, err := d.GetNetworkNames()
 	if err != nil {
-		return &SanityCheckError{
+		return &types.SanityCheckError{
 			Step: "get network names",
 			Err:  fmt.Errorf("error getting network names: %s", err),
 		}
 	}
-	// check to see if the default profiles exists
-	for _, p := range app.Profiles {
-		if !slices.Contains(profileNames, p) {
-			return &SanityCheckError{
-				Step: "check declared profile exists",
-				Err:  fmt.Errorf("profile '%s' does not exist in project '%s'", p, app.GetProject()),
+
+	// profiles and storage pools are checked by the validate tags
+	errs := []error{app.checkStruct(names)}
+	// check to see if the network declared exists
+	located := readComposeFiles(app.ComposeProject.ComposeFiles)
+	for _, name := range slices.Sorted(maps.Keys(app.ComposeProject.Services)) {
+		s := app.ComposeProject.Services[name]
+		for _, network := range slices.Sorted(maps.Keys(s.Networks)) {
+			if network == "default" {
+				continue
 			}
-		}
-	}
-	// check to see if the additional profiles exists
-	for _, s := range app.Services {
-		for _, p := range s.AdditionalProfiles {
-			if !slices.Contains(profileNames, p) {
-				return &SanityCheckError{
-					Step: "check declared profile exists",
-					Err:  fmt.Errorf("additional profile '%s' does not exist in project '%s'", p, app.GetProject()),
-				}
+			if !slices.Contains(netNames, network) {
+				errs = append(errs, missing(located, "check declared network exists", []string{"services", name, "networks", network},
+					"network '%s' does not exist in project '%s'", network, app.GetProject()))
 			}
 		}
 	}
-	// check to see if the instance declared storage pool exists
-	for _, s := range app.Services {
-		if s.Storage != "" {
-			if !slices.Contains(poolNames, s.Storage) {
-				return &SanityCheckError{
-					Step: "check declared storage pool exists",
-					Err:  fmt.Errorf("storage pool '%s' does not exist in project '%s'", s.Storage, app.GetProject()),
-				}
-			}
-		}
 
-		// check to see if the volume declared storage pool exists
-		for _, v := range s.Volumes {
-			if v.Pool != "" {
-				if !slices.Contains(poolNames, v.Pool) {
-					return &SanityCheckError{
-						Step: "check declared volume storage pool exists",
-						Err:  fmt.Errorf("volume %s: storage pool '%s' does not exist in project '%s'", v.Name, v.Pool, app.GetProject()),
-					}
-				}
-			}
+	return errors.Join(errs...)
+}
+
+// missingError reports the resource named by the field at path that the
+// remote doesn't have, located at the key of the compose file naming it.
+// A volume in the storage pool of its instance isn't reported, the pool
+// already is.
+func (app *Compose) missingError(located *composeFiles, path []string, value any) error {
+	project := app.GetProject()
+	switch {
+	case len(path) == 1 && path[0] == "project":
+		return missing(located, "check declared project exists", []string{"x-incus-project"},
+			"project '%s' does not exist", project)
+	case len(path) == 2 && path[0] == "profiles":
+		return missing(located, "check declared profile exists", []string{"x-incus-default-profiles", path[1]},
+			"profile '%s' does not exist in project '%s'", value, project)
+	case len(path) == 4 && path[2] == "additional_profiles":
+		return missing(located, "check declared profile exists", []string{"services", path[1], "x-incus-additional-profiles", path[3]},
+			"additional profile '%s' does not exist in project '%s'", value, project)
+	case len(path) == 3 && path[2] == "storage":
+		return missing(located, "check declared storage pool exists", []string{"services", path[1], "x-incus-storage"},
+			"storage pool '%s' does not exist in project '%s'", value, project)
+	case len(path) == 5 && path[2] == "volumes":
+		s := app.Services[path[1]]
+		v := s.Volumes[path[3]]
+		if v.Pool == s.Storage {
+			return nil
 		}
-	}
-	// check to see if the network declared exists
-	for _, s := range app.ComposeProject.Services {
-		if s.Networks != nil {
-			for name := range s.Networks {
-				if name == "default" {
-					continue
-				}
-				if !slices.Contains(netNames, name) {
-					return &SanityCheckError{
-						Step: "check declared network exists",
-						Err:  fmt.Errorf("network '%s' does not exist in project '%s'", name, app.GetProject()),
-					}
-				}
-			}
+		volPath := []string{"volumes", path[3]}
+		if vol, ok := app.ComposeProject.Volumes[path[3]]; ok && vol.DriverOpts["pool"] == v.Pool {
+			volPath = append(volPath, "driver_opts", "pool")
 		}
+		return missing(located, "check declared volume storage pool exists", volPath,
+			"volume %s: storage pool '%s' does not exist in project '%s'", v.Name, v.Pool, project)
+	default:
+		return missing(located, "check declared resource exists", path, "'%v' does not exist in project '%s'", value, project)
 	}
+}
 
-	return nil
+// missing returns the SanityCheckError of a resource missing on the remote,
+// located at path in the compose file
+func missing(located *composeFiles, step string, path []string, format string, args ...any) error {
+	err := located.errorAt(path, fmt.Errorf(format, args...))
+	err.Remote = true
+	return &types.SanityCheckError{Step: step, Err: err}
 }
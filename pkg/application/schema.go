@@ -0,0 +1,174 @@
+package application
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	"github.com/compose-spec/compose-go/v2/schema"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Schema returns the JSON Schema of compose files using the x-incus
+// extensions: the compose specification with the extensions described at
+// the top level, in services, in their volumes and in named volumes.
+// Editors use it to complete and check compose files.
+func Schema() ([]byte, error) {
+	var s map[string]any
+	err := json.Unmarshal([]byte(schema.Schema), &s)
+	if err != nil {
+		return nil, fmt.Errorf("compose specification schema: %w", err)
+	}
+	s["$id"] = "incus-compose.schema.json"
+	s["title"] = "incus-compose"
+	s["description"] = "The Compose file with the x-incus extensions of incus-compose."
+
+	targets := []struct {
+		path []string
+		ext  any
+	}{
+		{nil, ProjectExtensions{}},
+		{[]string{"definitions", "service"}, ServiceExtensions{}},
+		{[]string{"definitions", "volume"}, VolumeExtensions{}},
+		{[]string{"definitions", "service", "properties", "volumes", "items", "oneOf", "object"}, MountExtensions{}},
+	}
+	for _, target := range targets {
+		obj, err := schemaObject(s, target.path)
+		if err != nil {
+			return nil, err
+		}
+		properties, ok := obj["properties"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("compose specification schema: no properties in %s", strings.Join(target.path, "."))
+		}
+		t := reflect.TypeOf(target.ext)
+		for i := range t.NumField() {
+			field := t.Field(i)
+			properties[field.Tag.Get("mapstructure")] = fieldSchema(field)
+		}
+	}
+
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// schemaObject walks a schema down path. The "object" segment of a oneOf
+// picks the variant of type object.
+func schemaObject(s map[string]any, path []string) (map[string]any, error) {
+	var current any = s
+	for i, segment := range path {
+		switch node := current.(type) {
+		case map[string]any:
+			current = node[segment]
+		case []any:
+			idx := slices.IndexFunc(node, func(v any) bool {
+				variant, ok := v.(map[string]any)
+				return ok && variant["type"] == segment
+			})
+			if idx < 0 {
+				current = nil
+				break
+			}
+			current = node[idx]
+		}
+		if current == nil {
+			return nil, fmt.Errorf("compose specification schema: no %s", strings.Join(path[:i+1], "."))
+		}
+	}
+	obj, ok := current.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("compose specification schema: %s isn't an object", strings.Join(path, "."))
+	}
+	return obj, nil
+}
+
+// fieldSchema describes the values of an extension field
+func fieldSchema(field reflect.StructField) map[string]any {
+	s := map[string]any{}
+	t := field.Type
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		s["type"] = "boolean"
+	case reflect.String:
+		s["type"] = "string"
+	case reflect.Slice:
+		s["type"] = "array"
+		s["items"] = map[string]any{"type": "string"}
+	case reflect.Struct:
+		properties := map[string]any{}
+		for i := range t.NumField() {
+			properties[t.Field(i).Tag.Get("mapstructure")] = fieldSchema(t.Field(i))
+		}
+		s["type"] = "object"
+		s["properties"] = properties
+		s["additionalProperties"] = false
+	}
+	if description := field.Tag.Get("description"); description != "" {
+		s["description"] = description
+	}
+	return s
+}
+
+// ValidateModel checks a compose model, as loaded by compose-go, against
+// Schema. The errors are located in the compose files.
+func ValidateModel(model map[string]any, files []string) error {
+	s, err := Schema()
+	if err != nil {
+		return err
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(s), gojsonschema.NewGoLoader(model))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+
+	located := readComposeFiles(files)
+	errs := []error{}
+	for _, e := range mostSpecific(result.Errors()) {
+		errs = append(errs, located.errorAt(schemaPath(e), errors.New(e.Description())))
+	}
+	// the errors come in no particular order, report them as they appear in
+	// the compose files
+	slices.SortFunc(errs, func(a, b error) int {
+		va, vb := a.(*types.ValidationError), b.(*types.ValidationError)
+		return cmp.Or(
+			cmp.Compare(slices.Index(files, va.File), slices.Index(files, vb.File)),
+			cmp.Compare(va.Line, vb.Line),
+			cmp.Compare(va.Column, vb.Column),
+			cmp.Compare(va.Path, vb.Path),
+		)
+	})
+	return errors.Join(errs...)
+}
+
+// schemaPath returns the path of the element a schema error is about
+func schemaPath(e gojsonschema.ResultError) []string {
+	path := []string{}
+	if e.Field() != gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+		path = strings.Split(e.Field(), ".")
+	}
+	if property, ok := e.Details()["property"].(string); ok && e.Type() == "additional_property_not_allowed" {
+		path = append(path, property)
+	}
+	return path
+}
+
+// mostSpecific drops the errors about an element that has errors about its
+// children, like the oneOf failure of a mount with a mistyped extension
+func mostSpecific(errs []gojsonschema.ResultError) []gojsonschema.ResultError {
+	return slices.DeleteFunc(slices.Clone(errs), func(e gojsonschema.ResultError) bool {
+		path := strings.Join(schemaPath(e), ".")
+		return slices.ContainsFunc(errs, func(other gojsonschema.ResultError) bool {
+			return strings.HasPrefix(strings.Join(schemaPath(other), "."), path+".")
+		})
+	})
+}
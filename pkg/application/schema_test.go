@@ -0,0 +1,126 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bketelsen/incus-compose/pkg/compose"
+	"github.com/bketelsen/incus-compose/pkg/types"
+)
+
+func TestSchema(t *testing.T) {
+	s, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(s) + "\n"
+
+	golden := filepath.Join("..", "..", "schema", "incus-compose.schema.json")
+	if *update {
+		err := os.WriteFile(golden, []byte(got), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v, run the test with -update to create it", err)
+	}
+	if got != string(want) {
+		t.Errorf("schema differs from %s, run the test with -update if the change is expected", golden)
+	}
+}
+
+func TestValidateModel(t *testing.T) {
+	samples := []string{"ghost", "gitea", "verified/jellyfin"}
+	for _, sample := range samples {
+		t.Run(sample, func(t *testing.T) {
+			dir, err := filepath.Abs(filepath.Join("..", "..", "samples", sample))
+			if err != nil {
+				t.Fatal(err)
+			}
+			loader := compose.NewLoaderWithOptions(compose.LoaderOptions{WorkingDir: dir})
+			model, err := loader.LoadModel(context.Background())
+			if err != nil {
+				t.Fatalf("load model: %v", err)
+			}
+			err = ValidateModel(model, nil)
+			if err != nil {
+				t.Errorf("validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidateModelErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			name: "mistyped extensions",
+			yaml: `
+name: shop
+x-incus-project: [shop]
+services:
+  web:
+    image: alpine
+    volumes:
+      - type: bind
+        source: /srv
+        target: /srv
+        x-incus-shift: "yes"
+`,
+			want: []string{
+				`compose.yaml:3:1: x-incus-project: Invalid type. Expected: string, given: array`,
+				`compose.yaml:11:9: services.web.volumes.0.x-incus-shift: Invalid type. Expected: boolean, given: string`,
+			},
+		},
+		{
+			name: "snapshot",
+			yaml: `
+name: shop
+services:
+  web:
+    image: alpine
+volumes:
+  data:
+    x-incus-snapshot:
+      expire: 2w
+`,
+			want: []string{`compose.yaml:9:7: volumes.data.x-incus-snapshot.expire: Additional property expire is not allowed`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, "compose.yaml")
+			err := os.WriteFile(file, []byte(tt.yaml), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			model, err := compose.NewLoaderWithOptions(compose.LoaderOptions{WorkingDir: dir}).LoadModel(context.Background())
+			if err != nil {
+				t.Fatalf("load model: %v", err)
+			}
+
+			err = ValidateModel(model, []string{file})
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if !errors.Is(err, types.ErrComposeFile) {
+				t.Errorf("error %v isn't a compose file error", err)
+			}
+			got := strings.ReplaceAll(err.Error(), dir+string(filepath.Separator), "")
+			if got != strings.Join(tt.want, "\n") {
+				t.Errorf("error:\n%s\nwant:\n%s", got, strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
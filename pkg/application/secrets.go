@@ -4,102 +4,89 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-)
+	"path/filepath"
 
-func (app *Compose) CreateSecretsForService(service string) error {
-	slog.Info("Creating Secrets", slog.String("instance", service))
+	"github.com/bketelsen/incus-compose/pkg/types"
+)
 
-	svc, ok := app.Services[service]
-	if !ok {
-		return fmt.Errorf("service %s not found", service)
+// writeSecretsForService copies the secrets files used by a service into the
+// local .secrets/<service> directory and returns the absolute path of that
+// directory. It returns an empty path when the service has no secrets.
+func (app *Compose) writeSecretsForService(service string) (string, error) {
+	files, err := app.secretFilesForService(service)
+	if err != nil {
+		return "", err
 	}
-	containerName := svc.GetContainerName()
 
 	// add secrets files
-	if len(svc.Secrets) == 0 {
-		return nil
+	if len(files) == 0 {
+		return "", nil
+	}
+
+	for k, source := range files {
+		err := writeSecret(service, k, source)
+		if err != nil {
+			return "", err
+		}
 	}
 
+	return filepath.Abs(secretsDir(service))
+}
+
+// writeSecret (re)writes the local copy of a secret of a service from the
+// file it is read from
+func writeSecret(service, name, source string) error {
+	slog.Debug("Adding Secret", slog.String("instance", service), slog.String("secret name", name))
+
+	f, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+
+	dirPath := secretsDir(service)
+	if err = os.MkdirAll(dirPath, 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(dirPath, name), f, 0644)
+}
+
+// secretFilesForService maps the names of the secrets used by a service to
+// the files they are read from. Secrets without a file are left out.
+func (app *Compose) secretFilesForService(service string) (map[string]string, error) {
+	svc, ok := app.Services[service]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
+	}
+
+	files := map[string]string{}
 	for k := range svc.Secrets {
-		slog.Debug("Adding Secret", slog.String("instance", service), slog.String("secret name", k))
 		secretsFileId := fmt.Sprintf("%s_%s", app.Name, k)
 
 		sf, ok := app.SecretsFiles[secretsFileId]
-		if ok {
-			// create local secret file in the /.secrets/serviceName/ directory
-			secPath := fmt.Sprintf(".secrets/%s/%s", service, k)
-			dirPath := fmt.Sprintf(".secrets/%s", service)
-
-			f, err := os.ReadFile(sf.FilePath)
-			if err != nil {
-				return err
-			}
-
-			if _, err := os.Stat(secPath); os.IsNotExist(err) {
-				if err = os.MkdirAll(dirPath, 0755); err != nil {
-					return err
-				}
-
-				err = os.WriteFile(secPath, f, 0644)
-				if err != nil {
-					return err
-				}
-
-			} else {
-				// rewrite th secret file content
-				file, err := os.Create(secPath)
-				if err != nil {
-					return err
-				}
-				defer file.Close()
-
-				if _, err = file.Write(f); err != nil {
-					return err
-				}
-
-				if err = file.Sync(); err != nil {
-					return err
-				}
-
-			}
-
-			absPath, err := os.Getwd()
-			if err != nil {
-				return err
-			}
-			absPath = fmt.Sprintf("%s/%s", absPath, dirPath)
-			mntPath := "/run/secrets"
-			bindName := fmt.Sprintf("secrets-%s", service)
-
-			// check for existing bind
-			d, err := app.getInstanceServer(containerName)
-			if err != nil {
-				return err
-			}
-			d = d.UseProject(app.GetProject())
-
-			inst, _, err := d.GetInstance(containerName)
-			if err != nil {
-				return err
-			}
-
-			_, ok := inst.Devices[bindName]
-			if ok {
-				slog.Info("Device already exists", slog.String("name", bindName))
-				return nil
-			}
-
-			device := map[string]string{}
-			device["type"] = "disk"
-			device["source"] = absPath
-			device["path"] = mntPath
-
-			err = app.addDevice(service, bindName, device)
-			if err != nil {
-				return err
-			}
+		if !ok {
+			continue
 		}
+		files[k] = sf.FilePath
 	}
+	return files, nil
+}
+
+// secretsDir is the local directory the secrets of a service are copied to
+func secretsDir(service string) string {
+	return fmt.Sprintf(".secrets/%s", service)
+}
+
+func secretsDeviceName(service string) string {
+	return fmt.Sprintf("secrets-%s", service)
+}
 
-	return nil
+// secretsDevice prepares the instance's device entry that mounts the local
+// secrets directory at /run/secrets
+func secretsDevice(source string) map[string]string {
+	device := map[string]string{}
+	device["type"] = "disk"
+	device["source"] = source
+	device["path"] = "/run/secrets"
+	return device
 }
@@ -0,0 +1,103 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+
+	api "github.com/lxc/incus/v6/shared/api"
+	config "github.com/lxc/incus/v6/shared/cliconfig"
+)
+
+// Stack summarizes an incus-compose stack found on a remote
+type Stack struct {
+	Name      string   `json:"name" yaml:"name"`
+	Directory string   `json:"directory" yaml:"directory"`
+	Running   int      `json:"running" yaml:"running"`
+	Services  int      `json:"services" yaml:"services"`
+	Projects  []string `json:"projects" yaml:"projects"`
+}
+
+// ListStacks discovers the stacks on a remote, or on the default remote
+// when remote is empty, from the keys incus-compose sets on instances. Only
+// the current project is searched unless allProjects is set. No compose
+// file is needed.
+func ListStacks(ctx context.Context, conf *config.Config, remote string, allProjects bool) ([]Stack, error) {
+	if remote == "" {
+		remote = conf.DefaultRemote
+	}
+	d, err := connectIncus(conf, remote)
+	if err != nil {
+		return nil, err
+	}
+
+	var instances []api.Instance
+	if allProjects {
+		instances, err = d.GetInstancesAllProjects(api.InstanceTypeAny)
+	} else {
+		instances, err = d.GetInstances(api.InstanceTypeAny)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed listing instances: %w", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return groupStacks(instances), nil
+}
+
+// groupStacks summarizes the stacks the instances belong to. Instances not
+// created by incus-compose and the one-off instances of run are skipped.
+func groupStacks(instances []api.Instance) []Stack {
+	stacks := map[string]*Stack{}
+	services := map[string]map[string]bool{}
+	running := map[string]map[string]bool{}
+	for _, inst := range instances {
+		if inst.Config[labelKey] != "true" || inst.Config[runKey] == "true" {
+			continue
+		}
+		directory := inst.Config[directoryKey]
+		// instances created before stacks were labeled only know their
+		// directory, which is what compose names the project after
+		name := inst.Config[stackKey]
+		if name == "" {
+			name = filepath.Base(directory)
+		}
+		service := inst.Config[serviceKey]
+		if service == "" {
+			service = inst.Name
+		}
+		// the same stack may be deployed to several projects
+		service = inst.Project + "/" + service
+
+		// stacks with the same name in different directories are different
+		// stacks
+		key := name + "\x00" + directory
+		stack, ok := stacks[key]
+		if !ok {
+			stack = &Stack{Name: name, Directory: directory}
+			stacks[key] = stack
+			services[key] = map[string]bool{}
+			running[key] = map[string]bool{}
+		}
+		if inst.Project != "" && !slices.Contains(stack.Projects, inst.Project) {
+			stack.Projects = append(stack.Projects, inst.Project)
+		}
+		services[key][service] = true
+		if inst.StatusCode == api.Running {
+			running[key][service] = true
+		}
+	}
+
+	list := []Stack{}
+	for _, key := range slices.Sorted(maps.Keys(stacks)) {
+		stack := stacks[key]
+		stack.Services = len(services[key])
+		stack.Running = len(running[key])
+		slices.Sort(stack.Projects)
+		list = append(list, *stack)
+	}
+	return list
+}
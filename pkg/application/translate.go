@@ -0,0 +1,78 @@
+package application
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+	compose "github.com/compose-spec/compose-go/v2/types"
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// Translation is what incus-compose creates in Incus for a compose service
+type Translation struct {
+	// Image is the image reference the instance is created from
+	Image string `yaml:"image"`
+	// Instance is the instance as it is created, its source is filled in
+	// once the image is resolved on the server
+	Instance api.InstancesPost `yaml:"instance"`
+	// Volumes are the custom volumes of the service, sorted by name
+	Volumes []TranslatedVolume `yaml:"volumes,omitempty"`
+	// Devices are attached to the instance once it exists: the custom
+	// volumes and the bind mounts
+	Devices map[string]map[string]string `yaml:"devices,omitempty"`
+}
+
+// TranslatedVolume is a custom volume and the pool it's created in
+type TranslatedVolume struct {
+	Pool   string                 `yaml:"pool"`
+	Volume api.StorageVolumesPost `yaml:"volume"`
+}
+
+// translate turns a compose service into the instance, custom volumes and
+// devices incus-compose creates for it. It doesn't talk to Incus: networks
+// looks up the networks the service connects to and nicNetwork tells
+// whether NICs can refer to managed networks by name. Secrets are a local
+// directory mounted into the instance and aren't part of the translation.
+func (app *Compose) translate(sc compose.ServiceConfig, networks networkLookup, nicNetwork bool) (*Translation, error) {
+	svc, ok := app.Services[sc.Name]
+	if !ok {
+		return nil, &types.NotFoundError{Kind: "service", Name: sc.Name}
+	}
+
+	instance, err := app.translateInstance(sc, networks, nicNetwork)
+	if err != nil {
+		return nil, err
+	}
+	t := &Translation{Image: sc.Image, Instance: *instance}
+
+	containerName := svc.GetContainerName()
+	for _, volName := range slices.Sorted(maps.Keys(svc.Volumes)) {
+		vol := svc.Volumes[volName]
+		post, err := app.volumePost(sc.Name, vol.CreateName(app.Name, containerName, volName), *vol)
+		if err != nil {
+			return nil, err
+		}
+		t.Volumes = append(t.Volumes, TranslatedVolume{Pool: vol.Pool, Volume: post})
+	}
+
+	t.Devices, err = app.attachedDevices(sc.Name)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// attachedDevices returns the devices attached to the instance of a service
+// once it's created: the disks of its custom volumes and bind mounts, keyed
+// by device name.
+func (app *Compose) attachedDevices(service string) (map[string]map[string]string, error) {
+	devices, err := app.volumeDevicesForService(service)
+	if err != nil {
+		return nil, err
+	}
+	for bindName, bind := range app.Services[service].BindMounts {
+		devices[bindName] = bindDevice(bind)
+	}
+	return devices, nil
+}
@@ -0,0 +1,98 @@
+package application
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bketelsen/incus-compose/pkg/compose"
+	api "github.com/lxc/incus/v6/shared/api"
+	cliconfig "github.com/lxc/incus/v6/shared/cliconfig"
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// translateNetworks are the networks the samples connect to, other networks
+// are managed bridges
+var translateNetworks = map[string]api.Network{
+	"br5":     {Name: "br5", Type: "bridge", Managed: false},
+	"ovntest": {Name: "ovntest", Type: "ovn", Managed: true},
+}
+
+func TestTranslate(t *testing.T) {
+	samples := []string{
+		"ghost",
+		"gitea",
+		"network/declared",
+		"network/fromprofile",
+		"ovn/gitea-ovn",
+		"verified/jellyfin",
+	}
+	for _, sample := range samples {
+		t.Run(sample, func(t *testing.T) {
+			dir, err := filepath.Abs(filepath.Join("..", "..", "samples", sample))
+			if err != nil {
+				t.Fatal(err)
+			}
+			project, err := compose.NewLoaderWithOptions(compose.LoaderOptions{WorkingDir: dir}).LoadProject(context.Background())
+			if err != nil {
+				t.Fatalf("load project: %v", err)
+			}
+			app, err := BuildDirect(project, cliconfig.NewConfig("", true))
+			if err != nil {
+				t.Fatalf("build: %v", err)
+			}
+
+			networks := func(name string) (*api.Network, error) {
+				network, ok := translateNetworks[name]
+				if !ok {
+					network = api.Network{Name: name, Type: "bridge", Managed: true}
+				}
+				return &network, nil
+			}
+
+			translations := map[string]*Translation{}
+			for _, sc := range project.Services {
+				translations[sc.Name], err = app.translate(sc, networks, true)
+				if err != nil {
+					t.Fatalf("translate %s: %v", sc.Name, err)
+				}
+			}
+
+			var buf bytes.Buffer
+			enc := yaml.NewEncoder(&buf)
+			enc.SetIndent(2)
+			err = enc.Encode(translations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the samples are checked out anywhere
+			got := strings.ReplaceAll(buf.String(), dir, "$SAMPLE_DIR")
+
+			golden := filepath.Join("testdata", "translate", strings.ReplaceAll(sample, "/", "-")+".yaml")
+			if *update {
+				err := os.MkdirAll(filepath.Dir(golden), 0o755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(golden, []byte(got), 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run the test with -update to create it", err)
+			}
+			if got != string(want) {
+				t.Errorf("translation of %s differs from %s, run the test with -update if the change is expected:\n%s", sample, golden, got)
+			}
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package application
 
 import (
+	"time"
+
 	"github.com/compose-spec/compose-go/v2/types"
 	"github.com/dominikbraun/graph"
 	config "github.com/lxc/incus/v6/shared/cliconfig"
@@ -15,7 +17,19 @@ type Compose struct {
 	Dag            graph.Graph[string, string] `yaml:"-"`
 	ComposeProject *types.Project              `yaml:"-"`
 	SecretsFiles   map[string]SecretsFile      `yaml:"secretsfiles,omitempty"`
-	conf           *config.Config
+	// Parallel limits how many services are operated on concurrently, 0 means no limit
+	Parallel int `yaml:"-"`
+	// OperationTimeout bounds every Incus operation, 0 means no timeout
+	OperationTimeout time.Duration `yaml:"-"`
+	// Retry controls how Incus calls failing on transient errors are retried
+	Retry Retry `yaml:"-"`
+	conf  *config.Config
+	// connect connects to a remote, tests replace it with a fake backend
+	connect func(remote string) (Backend, error)
+	hooks   []Hook
+	// recreated holds the services whose instance a resumed run already
+	// created or recreated
+	recreated map[string]bool
 }
 
 type Service struct {
@@ -33,14 +47,14 @@ type Service struct {
 	Snapshot              *Snapshot          `yaml:"snapshot,omitempty"`
 	DependsOn             []string           `yaml:"depends_on,omitempty"`
 	InventoryGroups       []string           `yaml:"inventory_groups,omitempty"`
-	Storage               string             `yaml:"storage,omitempty"`
+	Storage               string             `yaml:"storage,omitempty" validate:"pool-exists"`
 	Secrets               map[string]Secret  `yaml:"secrets,omitempty"`
 }
 
 type Snapshot struct {
-	Schedule string `yaml:"schedule,omitempty"`
-	Expiry   string `yaml:"expiry,omitempty"`
-	Pattern  string `yaml:"pattern,omitempty"`
+	Schedule string `yaml:"schedule,omitempty" mapstructure:"schedule" description:"Cron expression or schedule alias, like @daily."`
+	Expiry   string `yaml:"expiry,omitempty" mapstructure:"expiry" description:"How long snapshots are kept, like 2w."`
+	Pattern  string `yaml:"pattern,omitempty" mapstructure:"pattern" description:"Pongo2 template of the snapshot names."`
 }
 
 type Volume struct {
@@ -0,0 +1,76 @@
+package application
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	api "github.com/lxc/incus/v6/shared/api"
+)
+
+// UpdateContainerForService rebuilds the instance of a service when its image
+// points to a newer fingerprint or OCI digest than the instance was built from.
+// Custom volumes, bind mounts and secrets stay attached to the instance, and a
+// running instance is started again once it has been rebuilt.
+func (app *Compose) UpdateContainerForService(ctx context.Context, service string) error {
+	slog.Info("Checking for updates", slog.String("instance", service))
+
+	actions, err := app.updateActions(service)
+	if err != nil {
+		return err
+	}
+	return app.applyActions(ctx, actions)
+}
+
+// rebuildInstance rebuilds the instance of a service from the image found by
+// resolveImage, stopping it first and starting it again when it is running.
+func (app *Compose) rebuildInstance(ctx context.Context, d Backend, service string, imgServer ImageSource, imgInfo *api.Image, source api.InstanceSource, running bool) error {
+	svc := app.Services[service]
+	containerName := svc.GetContainerName()
+
+	if running {
+		err := app.updateInstanceState(ctx, containerName, "stop", -1, false, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Rebuilding", slog.String("instance", containerName))
+	op, err := d.RebuildInstanceFromImage(imgServer, *imgInfo, containerName, api.InstanceRebuildPost{Source: source})
+	if err != nil {
+		return err
+	}
+	err = app.waitRemote(ctx, op)
+	if err != nil {
+		return err
+	}
+
+	// the rebuild keeps the instance devices, make sure everything declared
+	// in the compose file is attached
+	inst, _, err := d.GetInstance(containerName)
+	if err != nil {
+		return fmt.Errorf("failed loading instance %q: %w", containerName, err)
+	}
+	actions, err := app.volumeActions(service)
+	if err != nil {
+		return err
+	}
+	secrets, err := app.secretActions(service)
+	if err != nil {
+		return err
+	}
+	attach, err := app.deviceActions(service, inst.Devices)
+	if err != nil {
+		return err
+	}
+	actions = append(append(actions, secrets...), attach...)
+	err = app.applyActions(ctx, actions)
+	if err != nil {
+		return err
+	}
+
+	if running {
+		return app.StartContainerForService(ctx, service, true)
+	}
+	return nil
+}
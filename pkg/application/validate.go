@@ -0,0 +1,117 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Validate checks the application with the validate tags of its types, a
+// missing value is a validation error of the compose file. Unless offline
+// is set, SanityCheck then checks the resources the compose file refers to
+// exist on the remote.
+func (app *Compose) Validate(ctx context.Context, offline bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := app.checkStruct(nil)
+	if err != nil || offline {
+		return err
+	}
+	return app.SanityCheck(ctx)
+}
+
+// remoteNames are the resources of the remote the compose file may refer
+// to, looked up once by SanityCheck
+type remoteNames struct {
+	projects []string
+	profiles []string
+	pools    []string
+}
+
+// newValidator returns a validator of the application. The project-exists,
+// profile-exists and pool-exists tags check the names against remote, they
+// pass when remote is nil or its names weren't looked up.
+func (app *Compose) newValidator(remote *remoteNames) *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	exists := func(names func() []string) validator.Func {
+		return func(fl validator.FieldLevel) bool {
+			name := fl.Field().String()
+			if remote == nil || names() == nil || name == "" {
+				return true
+			}
+			return slices.Contains(names(), name)
+		}
+	}
+	_ = v.RegisterValidation("project-exists", func(fl validator.FieldLevel) bool {
+		// an empty project is the default project
+		return remote == nil || remote.projects == nil || slices.Contains(remote.projects, app.GetProject())
+	})
+	_ = v.RegisterValidation("profile-exists", exists(func() []string { return remote.profiles }))
+	_ = v.RegisterValidation("pool-exists", exists(func() []string { return remote.pools }))
+	return v
+}
+
+// checkStruct validates the application, or only the given fields of it.
+// A missing value is located in the compose file, a resource missing on
+// remote is a SanityCheckError.
+func (app *Compose) checkStruct(remote *remoteNames, fields ...string) error {
+	v := app.newValidator(remote)
+	var err error
+	if len(fields) > 0 {
+		err = v.StructPartial(app, fields...)
+	} else {
+		err = v.Struct(app)
+	}
+	var fieldErrs validator.ValidationErrors
+	if err != nil && !errors.As(err, &fieldErrs) {
+		return err
+	}
+	// services are a map, report them in a stable order
+	slices.SortStableFunc(fieldErrs, func(a, b validator.FieldError) int {
+		return strings.Compare(a.Namespace(), b.Namespace())
+	})
+
+	located := readComposeFiles(app.ComposeProject.ComposeFiles)
+	errs := []error{}
+	for _, fe := range fieldErrs {
+		path := fieldPath(fe.Namespace())
+		switch fe.Tag() {
+		case "required":
+			errs = append(errs, located.errorAt(path, errors.New("is required")))
+		case "project-exists", "profile-exists", "pool-exists":
+			if err := app.missingError(located, path, fe.Value()); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			errs = append(errs, located.errorAt(path, fmt.Errorf("fails the %s check", fe.Tag())))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var namespaceIndex = regexp.MustCompile(`\[([^\]]*)\]`)
+
+// fieldPath turns the namespace of a field, like Compose.services[web].image,
+// into a path in the compose file
+func fieldPath(namespace string) []string {
+	namespace = namespaceIndex.ReplaceAllString(namespace, ".$1")
+	path := strings.Split(namespace, ".")
+	// the first element is the type of the application
+	return path[1:]
+}
@@ -0,0 +1,165 @@
+package application
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bketelsen/incus-compose/pkg/types"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		offline bool
+		setup   func(f *fakeIncus)
+		want    []string
+	}{
+		{
+			name: "ok",
+			yaml: testCompose,
+		},
+		{
+			name:    "offline",
+			yaml:    testCompose + "x-incus-project: shop\nx-incus-default-profiles:\n  - gpu\n",
+			offline: true,
+		},
+		{
+			name: "missing project",
+			yaml: testCompose + "x-incus-project: shop\n",
+			want: []string{"Sanity Check: check declared project exists -> compose.yaml:14:1: x-incus-project: project 'shop' does not exist"},
+		},
+		{
+			name: "missing profiles",
+			yaml: `
+name: shop
+x-incus-default-profiles:
+  - default
+  - gpu
+services:
+  web:
+    image: alpine
+    x-incus-additional-profiles: [web]
+`,
+			want: []string{
+				"Sanity Check: check declared profile exists -> compose.yaml:5:5: x-incus-default-profiles.1: profile 'gpu' does not exist in project 'default'",
+				"Sanity Check: check declared profile exists -> compose.yaml:9:35: services.web.x-incus-additional-profiles.0: additional profile 'web' does not exist in project 'default'",
+			},
+		},
+		{
+			name: "missing pools",
+			yaml: `
+name: shop
+services:
+  db:
+    image: alpine
+    x-incus-storage: fast
+    volumes:
+      - data:/var/lib/db
+  web:
+    image: alpine
+    volumes:
+      - cache:/var/cache
+volumes:
+  data: {}
+  cache:
+    driver_opts:
+      pool: slow
+`,
+			setup: func(f *fakeIncus) { f.pools = []string{"default"} },
+			want: []string{
+				"Sanity Check: check declared storage pool exists -> compose.yaml:6:5: services.db.x-incus-storage: storage pool 'fast' does not exist in project 'default'",
+				"Sanity Check: check declared volume storage pool exists -> compose.yaml:17:7: volumes.cache.driver_opts.pool: volume shop-web-cache: storage pool 'slow' does not exist in project 'default'",
+			},
+		},
+		{
+			name: "missing network",
+			yaml: `
+name: shop
+services:
+  web:
+    image: alpine
+    networks:
+      - backend
+networks:
+  backend:
+    external: true
+`,
+			want: []string{"Sanity Check: check declared network exists -> compose.yaml:7:9: services.web.networks.backend: network 'backend' does not exist in project 'default'"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, fake := newTestApp(t, tt.yaml)
+			if tt.setup != nil {
+				tt.setup(fake)
+			}
+
+			err := app.Validate(context.Background(), tt.offline)
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("validate: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if !errors.Is(err, types.ErrSanityCheck) || types.ExitCode(err) != types.ExitSanityCheck {
+				t.Errorf("error %v isn't a sanity check error", err)
+			}
+			// the missing resources are located, but the compose file is valid
+			var validationErr *types.ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Line == 0 || errors.Is(err, types.ErrComposeFile) {
+				t.Errorf("error %v isn't located or is a compose file error", err)
+			}
+			got := strings.ReplaceAll(err.Error(), filepath.Dir(app.ComposeProject.ComposeFiles[0])+string(filepath.Separator), "")
+			if got != strings.Join(tt.want, "\n") {
+				t.Errorf("error:\n%s\nwant:\n%s", got, strings.Join(tt.want, "\n"))
+			}
+
+			// up runs the same checks
+			if upErr := app.SanityCheck(context.Background()); upErr == nil || upErr.Error() != err.Error() {
+				t.Errorf("sanity check: %v", upErr)
+			}
+		})
+	}
+}
+
+func TestValidateUnreachable(t *testing.T) {
+	app, _ := newTestApp(t, testCompose)
+	app.connect = func(remote string) (Backend, error) {
+		return nil, &types.IncusError{Remote: remote, Err: errors.New("connection refused")}
+	}
+
+	err := app.Validate(context.Background(), false)
+	var sanityErr *types.SanityCheckError
+	if !errors.As(err, &sanityErr) || sanityErr.Step != "get incus remote" {
+		t.Errorf("error %v isn't the connection error", err)
+	}
+
+	// offline doesn't connect
+	err = app.Validate(context.Background(), true)
+	if err != nil {
+		t.Errorf("validate offline: %v", err)
+	}
+}
+
+func TestValidateRequired(t *testing.T) {
+	app, _ := newTestApp(t, testCompose)
+	db := app.Services["db"]
+	db.Image = ""
+	app.Services["db"] = db
+
+	err := app.Validate(context.Background(), false)
+	if !errors.Is(err, types.ErrComposeFile) {
+		t.Fatalf("error %v isn't a compose file error", err)
+	}
+	// the image is located in the compose file
+	if !strings.HasSuffix(err.Error(), "compose.yaml:5:5: services.db.image: is required") {
+		t.Errorf("error: %v", err)
+	}
+}
@@ -1,31 +1,19 @@
 package application
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/url"
 	"os"
 	"path"
-	"path/filepath"
 	"time"
 
 	incus "github.com/lxc/incus/v6/client"
 	api "github.com/lxc/incus/v6/shared/api"
 )
 
-func (app *Compose) ExportVolume(pool, volume string) error {
-
-	slog.Info("Exporting", slog.String("volume", volume))
-
-	fullExportPath := filepath.Join(app.ExportPath, exportName(volume))
-	slog.Info("Export File", slog.String("path", fullExportPath))
-
-	return app.volumeExport(pool, volume, fullExportPath)
-
-}
-
-func (app *Compose) volumeExport(pool, volume, targetName string) error {
+func (app *Compose) volumeExport(ctx context.Context, pool, volume, targetName string) error {
 	// Parse remote
 	resources, err := app.ParseServers(pool)
 	if err != nil {
@@ -43,14 +31,19 @@ func (app *Compose) volumeExport(pool, volume, targetName string) error {
 		OptimizedStorage: false,
 	}
 	d := resource.server.UseProject(app.GetProject())
-	op, err := d.CreateStorageVolumeBackup(pool, volume, req)
+	// the server names backups, a backup made by a request accepted before
+	// its connection failed is left to expire
+	var op incus.Operation
+	_, err = app.submit(ctx, "create volume backup "+volume, func() error {
+		op, err = d.CreateStorageVolumeBackup(pool, volume, req)
+		return err
+	}, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create storage volume backup: %w", err)
 	}
-
-	err = op.Wait()
+	err = app.wait(ctx, op)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to create storage volume backup: %w", err)
 	}
 
 	// Get name of backup
@@ -69,7 +62,7 @@ func (app *Compose) volumeExport(pool, volume, targetName string) error {
 		// Delete backup after we're done
 		op, err = resource.server.DeleteStorageVolumeBackup(pool, volume, backupName)
 		if err == nil {
-			_ = op.Wait()
+			_ = app.wait(context.WithoutCancel(ctx), op)
 		}
 	}()
 
@@ -80,14 +73,23 @@ func (app *Compose) volumeExport(pool, volume, targetName string) error {
 
 	defer func() { _ = target.Close() }()
 
+	downloadCtx, cancel := app.operationContext(ctx)
+	defer cancel()
+	canceller, done := downloadCanceller(downloadCtx)
+	defer done()
+
 	backupFileRequest := incus.BackupFileRequest{
 		BackupFile: io.WriteSeeker(target),
+		Canceler:   canceller,
 	}
 
 	// Export tarball
 	_, err = resource.server.GetStorageVolumeBackupFile(pool, volume, backupName, &backupFileRequest)
 	if err != nil {
 		_ = os.Remove(targetName)
+		if downloadCtx.Err() != nil {
+			err = context.Cause(downloadCtx)
+		}
 		return fmt.Errorf("failed to fetch storage volume backup file: %w", err)
 	}
 
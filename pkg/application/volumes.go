@@ -1,53 +1,39 @@
 package application
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
 	"slices"
 	"sort"
 	"strings"
 
+	"github.com/bketelsen/incus-compose/pkg/types"
 	"github.com/gosimple/slug"
 	api "github.com/lxc/incus/v6/shared/api"
 )
 
-func (app *Compose) CreateVolumesForService(service string) error {
+// CreateVolumesForService creates the custom volumes of a service that
+// don't exist yet and updates the others.
+func (app *Compose) CreateVolumesForService(ctx context.Context, service string) error {
 	slog.Info("Creating Volumes", slog.String("instance", service))
 
-	svc, ok := app.Services[service]
-	if !ok {
-		return fmt.Errorf("service %s not found", service)
-	}
-	containerName := svc.GetContainerName()
-	for volName, vol := range svc.Volumes {
-		slog.Info("Creating volume", "name", volName)
-		completeName := vol.CreateName(app.Name, containerName, volName)
-		slog.Debug("Volume", slog.String("name", completeName), slog.String("pool", vol.Pool), slog.String("mountpoint", vol.Mountpoint))
-
-		existingVolume, _ := app.showVolume(containerName, completeName, *vol)
-
-		if existingVolume != nil && completeName == existingVolume.Name {
-			slog.Info("Volume found", slog.String("volume", completeName))
-		} else {
-			slog.Info("Creating volume", "name", completeName)
-			err := app.createVolume(completeName, *vol)
-			if err != nil {
-				return err
-			}
-		}
+	actions, err := app.volumeActions(service)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return app.applyActions(ctx, actions)
 }
 
 func (app *Compose) ListVolumesForService(service string) ([]string, error) {
 	slog.Info("Getting Volumes", slog.String("instance", service))
 	svc, ok := app.Services[service]
 	if !ok {
-		return []string{}, fmt.Errorf("service %s not found", service)
+		return []string{}, &types.NotFoundError{Kind: "service", Name: service}
 	}
 
 	containerName := svc.GetContainerName()
@@ -59,59 +45,69 @@ func (app *Compose) ListVolumesForService(service string) ([]string, error) {
 	return volumes, nil
 }
 
-func (app *Compose) DeleteVolumesForService(service string) error {
-	slog.Info("Deleting Volumes", slog.String("instance", service))
+// createVolume creates a custom volume in the pool of vol
+func (app *Compose) createVolume(ctx context.Context, newvol api.StorageVolumesPost, vol Volume) error {
+	slog.Info("Creating Volume", slog.String("volume", newvol.Name))
 
-	svc, ok := app.Services[service]
-	if !ok {
-		return fmt.Errorf("service %s not found", service)
+	// Parse remote
+	resources, err := app.ParseServers(vol.Pool)
+	if err != nil {
+		return err
 	}
-	containerName := svc.GetContainerName()
-
-	for volName, vol := range svc.Volumes {
-
-		completeName := vol.CreateName(app.Name, containerName, volName)
-		slog.Debug("Volume", slog.String("name", completeName), slog.String("pool", vol.Pool), slog.String("mountpoint", vol.Mountpoint))
-
-		existingVolume, _ := app.showVolume(containerName, completeName, *vol)
 
-		if existingVolume == nil || completeName != existingVolume.Name {
-			slog.Info("Volume not found", slog.String("volume", completeName))
-		} else {
-			err := app.deleteVolume(completeName, *vol)
-			if err != nil {
-				return err
-			}
-		}
+	resource := resources[0]
+	if resource.name == "" {
+		return fmt.Errorf("missing pool name")
 	}
 
-	return nil
+	// a retry finds the volume created by an accepted request
+	client := resource.server.UseProject(app.GetProject())
+	_, err = app.submit(ctx, "create volume "+newvol.Name, func() error {
+		return client.CreateStoragePoolVolume(vol.Pool, newvol)
+	}, func(err error) bool {
+		if !api.StatusErrorCheck(err, http.StatusConflict) {
+			return false
+		}
+		existing, _, getErr := client.GetStoragePoolVolume(vol.Pool, "custom", newvol.Name)
+		return getErr == nil && existing.Config[configHashKey] == newvol.Config[configHashKey]
+	})
+	return err
 }
 
-func (app *Compose) AttachVolumesForService(service string) error {
-	slog.Info("Attaching Volumes", slog.String("instance", service))
+// updateVolume replaces the configuration of an existing custom volume
+func (app *Compose) updateVolume(ctx context.Context, existing *api.StorageVolume, vol Volume, config map[string]string) error {
+	slog.Info("Updating Volume", slog.String("volume", existing.Name))
 
-	svc, ok := app.Services[service]
-	if !ok {
-		return fmt.Errorf("service %s not found", service)
-	}
-	containerName := svc.GetContainerName()
-	for volName, vol := range svc.Volumes {
-
-		err := app.attachVolume(vol.CreateName(app.Name, containerName, volName), service, *vol)
-		if err != nil {
-			return err
-		}
+	resources, err := app.ParseServers(vol.Pool)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	put := existing.Writable()
+	put.Config = config
+	client := resources[0].server.UseProject(app.GetProject())
+	return app.retry(ctx, "update volume "+existing.Name, func() error {
+		return client.UpdateStoragePoolVolume(vol.Pool, existing.Type, existing.Name, put, "")
+	})
 }
 
-func (app *Compose) createVolume(name string, vol Volume) error {
-	slog.Info("Creating Volume", slog.String("volume", name))
+// managedVolumeKey reports whether a custom volume key is set by
+// incus-compose and can be removed when it's no longer in the compose file
+func managedVolumeKey(k string) bool {
+	return strings.HasPrefix(k, "snapshots.") || strings.HasPrefix(k, labelKey)
+}
 
+// volumePost prepares the storage volume entry for a custom volume of a
+// service
+func (app *Compose) volumePost(service string, name string, vol Volume) (api.StorageVolumesPost, error) {
 	config := make(map[string]string)
 
+	labels, err := app.stackLabels(service, vol)
+	if err != nil {
+		return api.StorageVolumesPost{}, err
+	}
+	maps.Copy(config, labels)
+
 	if vol.Snapshot != nil {
 		if vol.Snapshot.Schedule != "" {
 			config["snapshots.schedule"] = vol.Snapshot.Schedule
@@ -148,24 +144,7 @@ func (app *Compose) createVolume(name string, vol Volume) error {
 	for k, v := range config {
 		newvol.Config[k] = v
 	}
-	// Parse remote
-	resources, err := app.ParseServers(vol.Pool)
-	if err != nil {
-		return err
-	}
-
-	resource := resources[0]
-	if resource.name == "" {
-		return fmt.Errorf("missing pool name")
-	}
-
-	client := resource.server.UseProject(app.GetProject())
-	err = client.CreateStoragePoolVolume(vol.Pool, newvol)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return newvol, nil
 }
 
 func (app *Compose) deleteVolume(name string, vol Volume) error {
@@ -196,45 +175,34 @@ func (app *Compose) deleteVolume(name string, vol Volume) error {
 	return nil
 }
 
-func (app *Compose) attachVolume(name string, service string, vol Volume) error {
-	slog.Info("Attaching Volume", slog.String("volume", name))
-
-	args := []string{"storage", "volume", "attach", vol.Pool, name, service, vol.Mountpoint}
-	args = append(args, "--project", app.GetProject())
-
-	slog.Debug("Incus Args", slog.String("args", fmt.Sprintf("%v", args)))
-
+// volumeDevicesForService returns the disk devices that mount the custom
+// volumes of a service, keyed by device name.
+func (app *Compose) volumeDevicesForService(service string) (map[string]map[string]string, error) {
 	svc, ok := app.Services[service]
 	if !ok {
-		return fmt.Errorf("service %s not found", service)
+		return nil, &types.NotFoundError{Kind: "service", Name: service}
 	}
 	containerName := svc.GetContainerName()
 
-	d, err := app.getInstanceServer(containerName)
-	if err != nil {
-		return err
-	}
-
-	d = d.UseProject(app.GetProject())
-
-	instance, etag, err := d.GetInstance(containerName)
-	if err != nil {
-		slog.Error(err.Error())
-	}
-
-	// Check if device exists
-	_, ok = instance.Devices[name]
-	if ok {
-		slog.Info("Device already exists", slog.String("volume", name))
-		return nil
+	devices := map[string]map[string]string{}
+	for volName, vol := range svc.Volumes {
+		name := vol.CreateName(app.Name, containerName, volName)
+		dev, err := volumeDevice(name, *vol)
+		if err != nil {
+			return nil, err
+		}
+		devices[name] = dev
 	}
+	return devices, nil
+}
 
+// volumeDevice prepares the instance's device entry for a custom volume
+func volumeDevice(name string, vol Volume) (map[string]string, error) {
 	volName, volType := parseVolume("custom", name)
 	if volType != "custom" {
-		return fmt.Errorf("only \"custom\" volumes can be attached to instances")
+		return nil, fmt.Errorf("only \"custom\" volumes can be attached to instances")
 	}
 
-	// Prepare the instance's device entry
 	dev := map[string]string{
 		"type":   "disk",
 		"pool":   vol.Pool,
@@ -245,15 +213,7 @@ func (app *Compose) attachVolume(name string, service string, vol Volume) error
 	if vol.ReadOnly {
 		dev["readonly"] = "true"
 	}
-
-	instance.Devices[name] = dev
-
-	op, err := d.UpdateInstance(containerName, instance.Writable(), etag)
-	if err != nil {
-		return err
-	}
-
-	return op.Wait()
+	return dev, nil
 }
 
 func (v *Volume) CreateName(application string, service string, volume string) string {
@@ -1,19 +1,16 @@
 package application
 
 import (
+	"context"
 	"fmt"
+	"net/http"
 	"time"
 
+	incus "github.com/lxc/incus/v6/client"
 	api "github.com/lxc/incus/v6/shared/api"
 )
 
-func (app *Compose) SnapshotVolume(pool, volume string, noexpiry, stateful, volumes bool) error {
-
-	return app.volumeSnapshot(pool, volume, snapshotName(volume), stateful, noexpiry, time.Now().Add(time.Hour*24*7))
-
-}
-
-func (app *Compose) volumeSnapshot(pool, volume, snapshotName string, stateful bool, noexpiry bool, expiration time.Time) error {
+func (app *Compose) volumeSnapshot(ctx context.Context, pool, volume, snapshotName string, stateful bool, noexpiry bool, expiration time.Time) error {
 	// Parse remote
 	resources, err := app.ParseServers(pool)
 	if err != nil {
@@ -34,11 +31,18 @@ func (app *Compose) volumeSnapshot(pool, volume, snapshotName string, stateful b
 		req.ExpiresAt = &expiration
 	}
 
-	op, err := resource.server.CreateStoragePoolVolumeSnapshot(pool, "custom", volume, req)
-	if err != nil {
+	// snapshot names carry the time they're taken, one that exists when
+	// retrying was taken by the accepted request
+	var op incus.Operation
+	wait, err := app.submit(ctx, "snapshot volume "+volume, func() error {
+		op, err = resource.server.CreateStoragePoolVolumeSnapshot(pool, "custom", volume, req)
+		return err
+	}, func(err error) bool {
+		return api.StatusErrorCheck(err, http.StatusConflict)
+	})
+	if !wait {
 		return err
 	}
 
-	return op.Wait()
-
+	return app.wait(ctx, op)
 }
@@ -3,6 +3,7 @@ package compose
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 
 	"github.com/bketelsen/incus-compose/pkg/types"
@@ -70,12 +71,33 @@ func (c Loader) LoadProject(ctx context.Context) (*compose.Project, error) {
 			return nil, types.ErrComposeFileNotFound
 		}
 
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", types.ErrComposeFile, err)
 	}
 
 	return project, nil
 }
 
+// LoadModel loads the compose files merged into a raw model, the YAML tree
+// the project is built from. Like LoadProject it checks the files against
+// the compose specification.
+func (c Loader) LoadModel(ctx context.Context) (map[string]any, error) {
+	projOpts, err := c.projectOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := projOpts.LoadModel(ctx)
+	if err != nil {
+		if errors.Is(err, errdefs.ErrNotFound) {
+			return nil, types.ErrComposeFileNotFound
+		}
+
+		return nil, fmt.Errorf("%w: %w", types.ErrComposeFile, err)
+	}
+
+	return model, nil
+}
+
 func (c *Loader) projectOptions() (*cli.ProjectOptions, error) {
 	options := c.options
 	// Based on how docker compose setup its own project options
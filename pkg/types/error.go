@@ -1,5 +1,165 @@
 package types
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/lxc/incus/v6/shared/api"
+)
 
 var ErrComposeFileNotFound = errors.New("no compose.yaml file found")
+
+// Kinds of errors, match them with errors.Is. Each kind has its own exit
+// code, see ExitCode.
+var (
+	// ErrUsage is an invalid flag or argument
+	ErrUsage = errors.New("invalid usage")
+	// ErrComposeFile is a compose file incus-compose can't use
+	ErrComposeFile = errors.New("invalid compose file")
+	// ErrSanityCheck is a compose file that doesn't match the Incus server
+	ErrSanityCheck = errors.New("sanity check failed")
+	// ErrNotFound is a missing service, instance, volume, network or run
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is a change that conflicts with the current state, like
+	// deleting a running instance or a stack locked by another run
+	ErrConflict = errors.New("conflict")
+	// ErrTimeout is an operation or command that ran out of time
+	ErrTimeout = errors.New("timeout")
+	// ErrIncusAPI is an error returned by Incus or the connection to it
+	ErrIncusAPI = errors.New("incus API error")
+	// ErrDrift is reported by diff when instances differ from the compose file
+	ErrDrift = errors.New("instances differ from the compose file")
+	// ErrIncomplete is reported by status when the last run didn't complete
+	ErrIncomplete = errors.New("the last run didn't complete")
+)
+
+// Exit codes of incus-compose
+const (
+	ExitOK          = 0
+	ExitFailure     = 1
+	ExitUsage       = 2
+	ExitComposeFile = 3
+	ExitSanityCheck = 4
+	ExitNotFound    = 5
+	ExitConflict    = 6
+	ExitTimeout     = 7
+	ExitIncusAPI    = 8
+	ExitDrift       = 10
+	ExitIncomplete  = 11
+	ExitInterrupted = 130
+)
+
+// ExitCode returns the exit code for an error. When an error wraps several
+// kinds, like the failures of several services, the first kind listed in
+// the exit codes wins, except for interruptions and timeouts which always
+// take precedence.
+func ExitCode(err error) int {
+	var exitErr *ExitError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.As(err, &exitErr):
+		return exitErr.Code
+	case errors.Is(err, context.Canceled):
+		return ExitInterrupted
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, ErrUsage):
+		return ExitUsage
+	case errors.Is(err, ErrComposeFileNotFound), errors.Is(err, ErrComposeFile):
+		return ExitComposeFile
+	case errors.Is(err, ErrSanityCheck):
+		return ExitSanityCheck
+	case errors.Is(err, ErrNotFound), api.StatusErrorCheck(err, http.StatusNotFound):
+		return ExitNotFound
+	case errors.Is(err, ErrConflict), api.StatusErrorCheck(err, http.StatusConflict, http.StatusPreconditionFailed):
+		return ExitConflict
+	case errors.Is(err, ErrIncusAPI), api.StatusErrorCheck(err):
+		return ExitIncusAPI
+	case errors.Is(err, ErrDrift):
+		return ExitDrift
+	case errors.Is(err, ErrIncomplete):
+		return ExitIncomplete
+	default:
+		return ExitFailure
+	}
+}
+
+// ExitError is a command run by exec or run that exited with a non-zero
+// code, incus-compose exits with the same code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string { return fmt.Sprintf("command exited with code %d", e.Code) }
+
+// SanityCheckError is returned when the compose file refers to projects,
+// profiles, storage pools or networks the Incus server doesn't have
+type SanityCheckError struct {
+	Step string
+	Err  error
+}
+
+func (e *SanityCheckError) Error() string { return "Sanity Check: " + e.Step + " -> " + e.Err.Error() }
+
+func (e *SanityCheckError) Unwrap() error { return e.Err }
+
+func (e *SanityCheckError) Is(target error) bool { return target == ErrSanityCheck }
+
+// NotFoundError is returned when a service, instance, volume or network
+// doesn't exist
+type NotFoundError struct {
+	Kind string
+	Name string
+}
+
+func (e *NotFoundError) Error() string { return fmt.Sprintf("%s %s not found", e.Kind, e.Name) }
+
+func (e *NotFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// IncusError is returned when connecting to Incus fails
+type IncusError struct {
+	Remote string
+	Err    error
+}
+
+func (e *IncusError) Error() string {
+	return fmt.Sprintf("failed connecting to remote %q: %s", e.Remote, e.Err)
+}
+
+func (e *IncusError) Unwrap() error { return e.Err }
+
+func (e *IncusError) Is(target error) bool { return target == ErrIncusAPI }
+
+// ValidationError is an element of a compose file that isn't valid, like a
+// mistyped x-incus extension. File, Line and Column locate the element when
+// they're known.
+type ValidationError struct {
+	File   string
+	Line   int
+	Column int
+	// Path is the element, like services.web.x-incus-gpu
+	Path string
+	Err  error
+	// Remote is set when the element names a resource the Incus server
+	// doesn't have, the compose file itself is valid
+	Remote bool
+}
+
+func (e *ValidationError) Error() string {
+	msg := e.Path + ": " + e.Err.Error()
+	switch {
+	case e.File == "":
+		return msg
+	case e.Line == 0:
+		return e.File + ": " + msg
+	default:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, msg)
+	}
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+func (e *ValidationError) Is(target error) bool { return target == ErrComposeFile && !e.Remote }
@@ -0,0 +1,54 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// DiffRow is one row of the diff table
+type DiffRow struct {
+	Service string
+	Object  string
+	Kind    string
+	Name    string
+	Change  string
+}
+
+func Diff(rows []DiffRow) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	var (
+		// HeaderStyle is the lipgloss style used for the table headers.
+		HeaderStyle = re.NewStyle().Foreground(purple).Bold(true).Padding(0, 1)
+		// CellStyle is the base lipgloss style used for the table rows.
+		CellStyle = re.NewStyle().Padding(0, 1)
+		// OddRowStyle is the lipgloss style used for odd-numbered table rows.
+		OddRowStyle = CellStyle.Foreground(lightGray)
+		// EvenRowStyle is the lipgloss style used for even-numbered table rows.
+		EvenRowStyle = CellStyle.Foreground(white)
+		// BorderStyle is the lipgloss style used for the table border.
+		BorderStyle = lipgloss.NewStyle().Foreground(purple)
+	)
+
+	t := table.New().
+		Border(lipgloss.ThickBorder()).
+		BorderStyle(BorderStyle).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			switch {
+			case row == table.HeaderRow:
+				return HeaderStyle
+			case row%2 == 0:
+				return EvenRowStyle
+			default:
+				return OddRowStyle
+			}
+		}).
+		Headers("Service", "Object", "Kind", "Name", "Change")
+	for _, r := range rows {
+		t.Row(r.Service, r.Object, r.Kind, r.Name, r.Change)
+	}
+
+	fmt.Println(t)
+}
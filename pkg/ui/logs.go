@@ -0,0 +1,97 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// logColors are used in turn for the service name prefixes of log lines
+var logColors = []lipgloss.Color{
+	lipgloss.Color("6"),
+	lipgloss.Color("3"),
+	lipgloss.Color("2"),
+	lipgloss.Color("5"),
+	lipgloss.Color("4"),
+	lipgloss.Color("14"),
+	lipgloss.Color("11"),
+	lipgloss.Color("10"),
+	lipgloss.Color("13"),
+	lipgloss.Color("12"),
+}
+
+// LogMux multiplexes the log lines of several services onto one writer,
+// prefixing every line with the colored name of its service.
+type LogMux struct {
+	out      io.Writer
+	renderer *lipgloss.Renderer
+	width    int
+	mu       sync.Mutex
+}
+
+// NewLogMux returns a LogMux writing to out. The service names are used to
+// align the prefixes.
+func NewLogMux(out io.Writer, services []string) *LogMux {
+	width := 0
+	for _, s := range services {
+		width = max(width, len(s))
+	}
+	return &LogMux{
+		out:      out,
+		renderer: lipgloss.NewRenderer(out),
+		width:    width,
+	}
+}
+
+// Writer returns a writer for the logs of a service. The index selects the
+// color of the prefix.
+func (m *LogMux) Writer(service string, index int) *LogWriter {
+	style := m.renderer.NewStyle().Foreground(logColors[index%len(logColors)])
+	prefix := style.Render(fmt.Sprintf("%-*s |", m.width, service)) + " "
+	return &LogWriter{mux: m, prefix: []byte(prefix)}
+}
+
+// LogWriter writes the prefixed log lines of one service.
+type LogWriter struct {
+	mux    *LogMux
+	prefix []byte
+	buf    []byte
+}
+
+// Write buffers partial lines and writes complete lines with the prefix.
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		err := w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+		if err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes a remaining partial line, if any.
+func (w *LogWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := append(w.buf, '\n')
+	w.buf = nil
+	return w.writeLine(line)
+}
+
+func (w *LogWriter) writeLine(line []byte) error {
+	w.mux.mu.Lock()
+	defer w.mux.mu.Unlock()
+
+	_, err := w.mux.out.Write(append(append([]byte{}, w.prefix...), line...))
+	return err
+}
@@ -0,0 +1,55 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// PlanRow is one row of the plan table
+type PlanRow struct {
+	Action  string
+	Kind    string
+	Service string
+	Name    string
+	Details []string
+}
+
+func Plan(rows []PlanRow) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	var (
+		// HeaderStyle is the lipgloss style used for the table headers.
+		HeaderStyle = re.NewStyle().Foreground(purple).Bold(true).Padding(0, 1)
+		// CellStyle is the base lipgloss style used for the table rows.
+		CellStyle = re.NewStyle().Padding(0, 1)
+		// OddRowStyle is the lipgloss style used for odd-numbered table rows.
+		OddRowStyle = CellStyle.Foreground(lightGray)
+		// EvenRowStyle is the lipgloss style used for even-numbered table rows.
+		EvenRowStyle = CellStyle.Foreground(white)
+		// BorderStyle is the lipgloss style used for the table border.
+		BorderStyle = lipgloss.NewStyle().Foreground(purple)
+	)
+
+	t := table.New().
+		Border(lipgloss.ThickBorder()).
+		BorderStyle(BorderStyle).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			switch {
+			case row == table.HeaderRow:
+				return HeaderStyle
+			case row%2 == 0:
+				return EvenRowStyle
+			default:
+				return OddRowStyle
+			}
+		}).
+		Headers("Action", "Kind", "Service", "Name", "Details")
+	for _, r := range rows {
+		t.Row(r.Action, r.Kind, r.Service, r.Name, strings.Join(r.Details, "\n"))
+	}
+
+	fmt.Println(t)
+}
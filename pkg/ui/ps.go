@@ -0,0 +1,57 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/lipgloss/table"
+)
+
+// PsRow is one row of the ps table
+type PsRow struct {
+	Service   string
+	Instance  string
+	Status    string
+	Image     string
+	Ports     []string
+	Addresses []string
+	Uptime    string
+}
+
+func Ps(rows []PsRow) {
+	re := lipgloss.NewRenderer(os.Stdout)
+	var (
+		// HeaderStyle is the lipgloss style used for the table headers.
+		HeaderStyle = re.NewStyle().Foreground(purple).Bold(true).Padding(0, 1)
+		// CellStyle is the base lipgloss style used for the table rows.
+		CellStyle = re.NewStyle().Padding(0, 1)
+		// OddRowStyle is the lipgloss style used for odd-numbered table rows.
+		OddRowStyle = CellStyle.Foreground(lightGray)
+		// EvenRowStyle is the lipgloss style used for even-numbered table rows.
+		EvenRowStyle = CellStyle.Foreground(white)
+		// BorderStyle is the lipgloss style used for the table border.
+		BorderStyle = lipgloss.NewStyle().Foreground(purple)
+	)
+
+	t := table.New().
+		Border(lipgloss.ThickBorder()).
+		BorderStyle(BorderStyle).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			switch {
+			case row == table.HeaderRow:
+				return HeaderStyle
+			case row%2 == 0:
+				return EvenRowStyle
+			default:
+				return OddRowStyle
+			}
+		}).
+		Headers("Service", "Instance", "Status", "Image", "Ports", "Addresses", "Uptime")
+	for _, r := range rows {
+		t.Row(r.Service, r.Instance, r.Status, r.Image, strings.Join(r.Ports, "\n"), strings.Join(r.Addresses, "\n"), r.Uptime)
+	}
+
+	fmt.Println(t)
+}